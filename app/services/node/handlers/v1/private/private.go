@@ -3,15 +3,23 @@ package private
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/ardanlabs/blockchain/business/web/errs"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
 	"github.com/ardanlabs/blockchain/foundation/web"
 	"go.uber.org/zap"
 )
 
 // Handlers manages the set of bar ledger endpoints.
 type Handlers struct {
-	Log *zap.SugaredLogger
+	Log   *zap.SugaredLogger
+	State *state.State
 }
 
 // Sample just provides a starting point for the class.
@@ -24,3 +32,126 @@ func (h Handlers) Sample(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 	return web.Respond(ctx, w, resp, http.StatusOK)
 }
+
+// ProposeBlock takes a block received from a peer, validates it and
+// if that passes, adds the block to the local blockchain.
+func (h Handlers) ProposeBlock(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	// Decode the JSON in the post call into a block data value.
+	var blockData database.BlockData
+	if err := web.Decode(r, &blockData); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	// Convert the block data into a block. This action will create a merkle
+	// tree for the set of transactions required for blockchain operations.
+	block, err := database.ToBlock(blockData)
+	if err != nil {
+		return fmt.Errorf("unable to decode block: %w", err)
+	}
+
+	h.Log.Infow("propose block", "traceid", v.TraceID, "number", block.Header.Number, "hash", block.Hash(), "beneficiary", block.Header.BeneficiaryID)
+
+	// Ask the state package to process this proposed block from a peer.
+	if err := h.State.ProcessProposedBlock(block); err != nil {
+		if errors.Is(err, database.ErrChainForked) {
+			return errs.NewTrusted(errors.New("chain is forked"), http.StatusNotAcceptable)
+		}
+
+		return errs.NewTrusted(fmt.Errorf("block not accepted: %w", err), http.StatusNotAcceptable)
+	}
+
+	resp := struct {
+		Status string `json:"status"`
+	}{
+		Status: "accepted",
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// SubmitNodeTransaction adds new node transactions to the mempool.
+func (h Handlers) SubmitNodeTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	// Decode the JSON in the post call into a block transaction.
+	var tx database.BlockTx
+	if err := web.Decode(r, &tx); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	h.Log.Infow("add node tran", "traceid", v.TraceID, "sig:nonce", tx, "from", tx.FromID, "to", tx.ToID, "value", tx.Value, "tip", tx.Tip)
+
+	// Ask the state package to add this transaction to the mempool.
+	if err := h.State.UpsertNodeTransaction(tx); err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	resp := struct {
+		Status string `json:"status"`
+	}{
+		Status: "added",
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// Status returns the current status of the node.
+func (h Handlers) Status(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	latestBlock := h.State.LatestBlock()
+
+	status := peer.PeerStatus{
+		LatestBlockHash:   latestBlock.Hash(),
+		LatestBlockNumber: latestBlock.Header.Number,
+		TotalWork:         h.State.TotalWork(),
+		KnownPeers:        h.State.KnownExternalPeers(),
+	}
+
+	return web.Respond(ctx, w, status, http.StatusOK)
+}
+
+// BlocksByNumber returns all the blocks based on the specified from/to values.
+// The word latest can be used for either value to reference the latest block.
+func (h Handlers) BlocksByNumber(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	from, err := parseBlockNumber(web.Param(r, "from"))
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	to, err := parseBlockNumber(web.Param(r, "to"))
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	if from > to {
+		return errs.NewTrusted(errors.New("from greater than to"), http.StatusBadRequest)
+	}
+
+	blocks := h.State.QueryBlocksByNumber(from, to)
+
+	blocksData := make([]database.BlockData, len(blocks))
+	for i, block := range blocks {
+		blocksData[i] = database.NewBlockData(block)
+	}
+
+	return web.Respond(ctx, w, blocksData, http.StatusOK)
+}
+
+// =============================================================================
+
+// parseBlockNumber converts the block number parameter into an integer. The
+// word latest or an empty value represents the latest block.
+func parseBlockNumber(num string) (uint64, error) {
+	if num == "latest" || num == "" {
+		return state.QueryLatest, nil
+	}
+
+	return strconv.ParseUint(num, 10, 64)
+}
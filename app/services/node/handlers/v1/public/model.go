@@ -30,4 +30,34 @@ type tx struct {
 	GasPrice    uint64             `json:"gas_price"`
 	GasUnits    uint64             `json:"gas_units"`
 	Sig         string             `json:"sig"`
+	Proof       []string           `json:"proof,omitempty"`
+	ProofOrder  []int64            `json:"proof_order,omitempty"`
+}
+
+type uncommitted struct {
+	Pending []tx `json:"pending"`
+	Queued  []tx `json:"queued"`
+}
+
+type block struct {
+	Number          uint64             `json:"number"`
+	Hash            string             `json:"hash"`
+	PrevBlockHash   string             `json:"prev_block_hash"`
+	TimeStamp       uint64             `json:"timestamp"`
+	BeneficiaryID   database.AccountID `json:"beneficiary"`
+	BeneficiaryName string             `json:"beneficiary_name"`
+	Difficulty      uint64             `json:"difficulty"`
+	MiningReward    uint64             `json:"mining_reward"`
+	StateRoot       string             `json:"state_root"`
+	TransRoot       string             `json:"trans_root"`
+	Nonce           uint64             `json:"nonce"`
+	Transactions    []tx               `json:"txs"`
+}
+
+type txProof struct {
+	BlockNumber uint64   `json:"block_number"`
+	TxHash      string   `json:"tx_hash"`
+	Proof       []string `json:"proof"`
+	Order       []int64  `json:"order"`
+	TransRoot   string   `json:"trans_root"`
 }
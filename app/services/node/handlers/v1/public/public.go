@@ -3,17 +3,28 @@ package public
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ardanlabs/blockchain/business/web/errs"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
 	"github.com/ardanlabs/blockchain/foundation/nameservice"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/ardanlabs/blockchain/foundation/web"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"go.uber.org/zap"
 )
 
+// The number of blocks returned on a page when listing blocks.
+const (
+	defaultRows = 20
+	maxRows     = 100
+)
+
 // Handlers manages the set of bar ledger endpoints.
 type Handlers struct {
 	Log   *zap.SugaredLogger
@@ -47,10 +58,10 @@ func (h Handlers) SubmitWalletTransaction(ctx context.Context, w http.ResponseWr
 
 	h.Log.Infow("add tran", "traceid", v.TraceID, "sig:nonce", signedTx, "from", signedTx.FromID, "to", signedTx.ToID, "value", signedTx.Value, "tip", signedTx.Tip)
 
-	// Ask the state package to add this transaction to the mempool. Only the
-	// checks are the transaction signature and the recipient account format.
-	// It's up to the wallet to make sure the account has a proper balance and
-	// nonce. Fees will be taken if this transaction is mined into a block.
+	// Ask the state package to add this transaction to the mempool. The
+	// transaction is rejected if the signature or account formats are bad,
+	// the nonce isn't usable or the account can't cover the cost. Fees will
+	// be taken if this transaction is mined into a block.
 	if err := h.State.UpsertWalletTransaction(signedTx); err != nil {
 		return errs.NewTrusted(err, http.StatusBadRequest)
 	}
@@ -112,37 +123,310 @@ func (h Handlers) Accounts(ctx context.Context, w http.ResponseWriter, r *http.R
 	return web.Respond(ctx, w, ai, http.StatusOK)
 }
 
-// Mempool returns the set of uncommitted transactions.
+// Mempool returns the set of uncommitted transactions. The transactions that
+// can be mined are returned separately from the transactions queued behind a
+// missing nonce.
 func (h Handlers) Mempool(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	acct := web.Param(r, "account")
 
-	mempool := h.State.Mempool()
-
 	//保证即使内存池空的也会返回一个空的交易列表
-	trans := []tx{}
+	resp := uncommitted{
+		Pending: h.filterTxs(h.State.Mempool(), acct),
+		Queued:  h.filterTxs(h.State.MempoolQueued(), acct),
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// TransactionProof returns the merkle proof that the specified transaction is
+// in the specified block. The proof can be checked against the TransRoot of
+// the block's header with merkle.VerifyProof.
+func (h Handlers) TransactionProof(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	num, err := parseBlockNumber(web.Param(r, "block"))
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	block, err := h.State.QueryBlockByNumber(num)
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusNotFound)
+	}
+
+	txHash := web.Param(r, "txhash")
+	for _, tx := range block.MerkleTree.Values() {
+		if signature.Hash(tx) != txHash {
+			continue
+		}
+
+		proof, order, err := block.MerkleTree.Proof(tx)
+		if err != nil {
+			return err
+		}
+
+		resp := txProof{
+			BlockNumber: block.Header.Number,
+			TxHash:      txHash,
+			Proof:       make([]string, len(proof)),
+			Order:       order,
+			TransRoot:   block.Header.TransRoot,
+		}
+		for i, hash := range proof {
+			resp.Proof[i] = hexutil.Encode(hash)
+		}
+
+		return web.Respond(ctx, w, resp, http.StatusOK)
+	}
+
+	return errs.NewTrusted(fmt.Errorf("transaction %s is not in block %d", txHash, block.Header.Number), http.StatusNotFound)
+}
+
+// BlocksByNumber returns a page of the blocks based on the specified from/to
+// values. The word latest can be used for either value to reference the
+// latest block. The page and rows query parameters select the page, which
+// defaults to the first page of 20 blocks.
+func (h Handlers) BlocksByNumber(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	from, err := parseBlockNumber(web.Param(r, "from"))
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	to, err := parseBlockNumber(web.Param(r, "to"))
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	page, rows, err := parsePage(r)
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	if from > to {
+		return errs.NewTrusted(errors.New("from greater than to"), http.StatusBadRequest)
+	}
+
+	latestNumber := h.State.LatestBlock().Header.Number
+	if from == state.QueryLatest {
+		from = latestNumber
+	}
+	if to == state.QueryLatest || to > latestNumber {
+		to = latestNumber
+	}
+	if from == 0 {
+		from = 1
+	}
+
+	resp := []block{}
+
+	// Skip to the first block on the requested page. A range past the end
+	// of the chain or a page past the end of the range is empty.
+	if from > to || page-1 > (to-from)/rows {
+		return web.Respond(ctx, w, resp, http.StatusOK)
+	}
+	from += (page - 1) * rows
+	if to-from >= rows {
+		to = from + rows - 1
+	}
+
+	for _, blk := range h.State.QueryBlocksByNumber(from, to) {
+		b, err := h.toBlock(blk)
+		if err != nil {
+			return err
+		}
+		resp = append(resp, b)
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// BlockByReference returns the specified block. The block can be referenced
+// by its number, its hash or the word latest.
+func (h Handlers) BlockByReference(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ref := web.Param(r, "block")
+
+	var blk database.Block
+	switch {
+	case strings.HasPrefix(ref, "0x"):
+		var err error
+		if blk, err = h.State.QueryBlockByHash(ref); err != nil {
+			return errs.NewTrusted(fmt.Errorf("block %s does not exist", ref), http.StatusNotFound)
+		}
+
+	default:
+		num, err := parseBlockNumber(ref)
+		if err != nil {
+			return errs.NewTrusted(err, http.StatusBadRequest)
+		}
+
+		if blk, err = h.State.QueryBlockByNumber(num); err != nil {
+			if errors.Is(err, database.ErrPruned) {
+				return errs.NewTrusted(err, http.StatusGone)
+			}
+			return errs.NewTrusted(err, http.StatusNotFound)
+		}
+	}
+
+	resp, err := h.toBlock(blk)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// BlocksByAccount returns the blocks that contain transactions sent or
+// received by the specified account. Each transaction carries the merkle
+// proof that it's in the block, so a wallet can check it against the
+// block's TransRoot.
+func (h Handlers) BlocksByAccount(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	accountID, err := database.ToAccountID(web.Param(r, "account"))
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	resp := []block{}
+	for _, blk := range h.State.QueryBlocksByAccount(accountID) {
+		b, err := h.toBlock(blk)
+		if err != nil {
+			return err
+		}
+		resp = append(resp, b)
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// HeadersByNumber returns the block headers based on the specified from/to
+// values. The word latest can be used for either value to reference the
+// latest block. Each header comes with the beneficiary's signature and
+// without the transactions. Light clients use this to follow the chain.
+func (h Handlers) HeadersByNumber(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	from, err := parseBlockNumber(web.Param(r, "from"))
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	to, err := parseBlockNumber(web.Param(r, "to"))
+	if err != nil {
+		return errs.NewTrusted(err, http.StatusBadRequest)
+	}
+
+	if from > to {
+		return errs.NewTrusted(errors.New("from greater than to"), http.StatusBadRequest)
+	}
+
+	headers := []database.BlockData{}
+	headers = append(headers, h.State.QueryHeadersByNumber(from, to)...)
+
+	return web.Respond(ctx, w, headers, http.StatusOK)
+}
+
+// =============================================================================
+
+// toTx converts the block transaction into the transaction model with the
+// account names resolved.
+func (h Handlers) toTx(tran database.BlockTx) tx {
+	return tx{
+		FromAccount: tran.FromID,
+		FromName:    h.NS.Lookup(tran.FromID),
+		ToName:      h.NS.Lookup(tran.ToID),
+		To:          tran.ToID,
+		ChainID:     tran.ChainID,
+		Nonce:       tran.Nonce,
+		Value:       tran.Value,
+		Tip:         tran.Tip,
+		Data:        tran.Data,
+		TimeStamp:   tran.TimeStamp,
+		GasPrice:    tran.GasPrice,
+		GasUnits:    tran.GasUnits,
+		Sig:         tran.SignatureString(),
+	}
+}
+
+// filterTxs converts the transactions into the transaction model. If an
+// account is specified, only the transactions sent or received by that
+// account are kept.
+func (h Handlers) filterTxs(trans []database.BlockTx, acct string) []tx {
+	out := []tx{}
 	//遍历 mempool 中的所有交易，并筛选出与特定账户（acct）相关的交易。如果交易的发送方或接收方与指定的账户匹配，则继续处理该交易；否则，跳过该交易
-	for _, tran := range mempool {
+	for _, tran := range trans {
 		//把与账户匹配的交易赛选
 		if acct != "" && ((acct != string(tran.FromID)) && (acct != string(tran.ToID))) {
 			continue
 		}
 
-		trans = append(trans, tx{
-			FromAccount: tran.FromID,
-			FromName:    h.NS.Lookup(tran.FromID),
-			ToName:      h.NS.Lookup(tran.ToID),
-			To:          tran.ToID,
-			ChainID:     tran.ChainID,
-			Nonce:       tran.Nonce,
-			Value:       tran.Value,
-			Tip:         tran.Tip,
-			Data:        tran.Data,
-			TimeStamp:   tran.TimeStamp,
-			GasPrice:    tran.GasPrice,
-			GasUnits:    tran.GasUnits,
-			Sig:         tran.SignatureString(),
-		})
-	}
-
-	return web.Respond(ctx, w, trans, http.StatusOK)
+		out = append(out, h.toTx(tran))
+	}
+
+	return out
+}
+
+// toBlock converts the block into the block model. Each transaction carries
+// its merkle proof.
+func (h Handlers) toBlock(blk database.Block) (block, error) {
+	b := block{
+		Number:          blk.Header.Number,
+		Hash:            blk.Hash(),
+		PrevBlockHash:   blk.Header.PrevBlockHash,
+		TimeStamp:       blk.Header.TimeStamp,
+		BeneficiaryID:   blk.Header.BeneficiaryID,
+		BeneficiaryName: h.NS.Lookup(blk.Header.BeneficiaryID),
+		Difficulty:      blk.Header.Difficulty,
+		MiningReward:    blk.Header.MiningReward,
+		StateRoot:       blk.Header.StateRoot,
+		TransRoot:       blk.Header.TransRoot,
+		Nonce:           blk.Header.Nonce,
+		Transactions:    []tx{},
+	}
+
+	for _, tran := range blk.MerkleTree.Values() {
+		proof, order, err := blk.MerkleTree.Proof(tran)
+		if err != nil {
+			return block{}, err
+		}
+
+		t := h.toTx(tran)
+		t.Proof = make([]string, len(proof))
+		for i, hash := range proof {
+			t.Proof[i] = hexutil.Encode(hash)
+		}
+		t.ProofOrder = order
+
+		b.Transactions = append(b.Transactions, t)
+	}
+
+	return b, nil
+}
+
+// parsePage reads the page and rows query parameters. The page starts at 1.
+func parsePage(r *http.Request) (uint64, uint64, error) {
+	page := uint64(1)
+	rows := uint64(defaultRows)
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n == 0 {
+			return 0, 0, fmt.Errorf("invalid page %q", v)
+		}
+		page = n
+	}
+
+	if v := r.URL.Query().Get("rows"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n == 0 || n > maxRows {
+			return 0, 0, fmt.Errorf("invalid rows %q, must be between 1 and %d", v, maxRows)
+		}
+		rows = n
+	}
+
+	return page, rows, nil
+}
+
+// parseBlockNumber converts the block number parameter into an integer. The
+// word latest or an empty value represents the latest block.
+func parseBlockNumber(num string) (uint64, error) {
+	if num == "latest" || num == "" {
+		return state.QueryLatest, nil
+	}
+
+	return strconv.ParseUint(num, 10, 64)
 }
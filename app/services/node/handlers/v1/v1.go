@@ -34,16 +34,25 @@ func PublicRoutes(app *web.App, cfg Config) {
 	app.Handle(http.MethodGet, version, "/accounts/list/:account", pbl.Accounts)
 	app.Handle(http.MethodGet, version, "/tx/uncommitted/list", pbl.Mempool)
 	app.Handle(http.MethodGet, version, "/tx/uncommitted/list/:account", pbl.Mempool)
+	app.Handle(http.MethodGet, version, "/headers/list/:from/:to", pbl.HeadersByNumber)
+	app.Handle(http.MethodGet, version, "/blocks/list/:from/:to", pbl.BlocksByNumber)
+	app.Handle(http.MethodGet, version, "/blocks/list/:account", pbl.BlocksByAccount)
+	app.Handle(http.MethodGet, version, "/blocks/:block", pbl.BlockByReference)
 	app.Handle(http.MethodPost, version, "/tx/submit", pbl.SubmitWalletTransaction)
-	app.Handle(http.MethodPost, version, "/tx/proof/:block/", pbl.SubmitWalletTransaction)
+	app.Handle(http.MethodGet, version, "/tx/proof/:block/:txhash", pbl.TransactionProof)
 
 }
 
 // PrivateRoutes binds all the version 1 private routes.
 func PrivateRoutes(app *web.App, cfg Config) {
 	prv := private.Handlers{
-		Log: cfg.Log,
+		Log:   cfg.Log,
+		State: cfg.State,
 	}
 
 	app.Handle(http.MethodGet, version, "/node/sample", prv.Sample)
+	app.Handle(http.MethodGet, version, "/node/status", prv.Status)
+	app.Handle(http.MethodGet, version, "/node/block/list/:from/:to", prv.BlocksByNumber)
+	app.Handle(http.MethodPost, version, "/node/block/propose", prv.ProposeBlock)
+	app.Handle(http.MethodPost, version, "/node/tx/submit", prv.SubmitNodeTransaction)
 }
@@ -6,14 +6,23 @@ import (
 	"fmt"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/index"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/snapshot"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/blocklog"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/disk"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/memory"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/pruned"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/worker"
 	"github.com/ardanlabs/blockchain/foundation/nameservice"
 	"github.com/ethereum/go-ethereum/crypto"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -64,9 +73,20 @@ func run(log *zap.SugaredLogger) error {
 			PrivateHost     string        `conf:"default:0.0.0.0:9080"`
 		}
 		State struct {
-			Beneficiary    string `conf:"default:miner1"`
-			SelectStrategy string `conf:"default:Tip"`
-			DBPath         string `conf:"default:zblock/miner1/"`
+			Beneficiary       string        `conf:"default:miner1"`
+			SelectStrategy    string        `conf:"default:Tip"`
+			DBPath            string        `conf:"default:zblock/miner1/"`
+			Storage           string        `conf:"default:disk"`     // Change to memory to not persist the chain, blocklog for segment files or pruned to drop old transactions
+			Codec             string        `conf:"default:json"`     // Change to rlp for a compact binary encoding
+			SnapshotBlocks    uint64        `conf:"default:100"`      // Blocks between account snapshots, 0 disables them
+			PruneDepth        uint64        `conf:"default:1000"`     // Recent blocks that keep their transactions with pruned storage
+			MempoolTxs        int           `conf:"default:5000"`     // Transactions held in the mempool, 0 is unlimited
+			MempoolAccountTxs int           `conf:"default:64"`       // Transactions held in the mempool for one account, 0 is unlimited
+			MempoolBytes      int           `conf:"default:16777216"` // Approximate bytes held in the mempool, 0 is unlimited
+			MempoolTTL        time.Duration `conf:"default:3h"`       // Time a transaction is held in the mempool, 0 holds it until it's mined
+			KnownPeers        []string      `conf:"default:0.0.0.0:9080;0.0.0.0:9280"`
+			Consensus         string        `conf:"default:POW"` // Change to POA to run Proof of Authority
+			MiningWorkers     int           `conf:"default:0"`   // 0 searches for a nonce on every CPU
 		}
 		NameService struct {
 			Folder string `conf:"default:zblock/accounts/"`
@@ -139,11 +159,54 @@ func run(log *zap.SugaredLogger) error {
 		log.Infow(s, "traceid", "00000000-0000-0000-0000-000000000000")
 	}
 
-	// Construct the use of disk storage.
-	storage, err := memory.New()
+	// Construct the use of a peer set for node communication, seeded with
+	// the peers provided by configuration.
+	peerSet := peer.NewPeerSet()
+	for _, host := range cfg.State.KnownPeers {
+		peerSet.Add(peer.New(host))
+	}
+
+	// Construct the use of the configured storage. The chain persisted in
+	// storage is replayed when the state is constructed.
+	// Pruned storage relies on the snapshots to rebuild the accounts.
+	if cfg.State.Storage == "pruned" && cfg.State.SnapshotBlocks == 0 {
+		return errors.New("pruned storage requires snapshots, set snapshot-blocks")
+	}
+	storage, err := newStorage(cfg.State.Storage, cfg.State.DBPath, cfg.State.Codec, cfg.State.PruneDepth)
 	if err != nil {
 		return err
 	}
+
+	// Construct the index of blocks and transactions by hash. The index is
+	// kept next to the persisted chain and is rebuilt from storage if it's
+	// missing or behind.
+	var indexPath string
+	if cfg.State.Storage != "memory" {
+		indexPath = filepath.Join(cfg.State.DBPath, "index.jsonl")
+	}
+	index, err := index.New(indexPath)
+	if err != nil {
+		return err
+	}
+
+	// Construct the account snapshots so the node only replays the blocks
+	// after the latest snapshot on startup.
+	var snapshots database.Snapshotter
+	if cfg.State.Storage != "memory" && cfg.State.SnapshotBlocks > 0 {
+		if snapshots, err = snapshot.New(filepath.Join(cfg.State.DBPath, "snapshots"), cfg.State.SnapshotBlocks); err != nil {
+			return err
+		}
+	}
+
+	// Construct the mempool journal so the uncommitted transactions are
+	// reloaded when the node restarts.
+	var journal *mempool.Journal
+	if cfg.State.Storage != "memory" {
+		if journal, err = mempool.OpenJournal(filepath.Join(cfg.State.DBPath, "mempool.jsonl")); err != nil {
+			return err
+		}
+	}
+
 	// Load the genesis file for blockchain settings and origin balances.
 	genesis, err := genesis.Load()
 	if err != nil {
@@ -154,8 +217,22 @@ func run(log *zap.SugaredLogger) error {
 	// database and provides an API for application support.
 	state, err := state.New(state.Config{
 		BeneficiaryID:  database.PublicKeyToAccountID(privateKey.PublicKey),
+		BeneficiaryKey: privateKey,
+		Host:           cfg.Web.PrivateHost,
+		KnownPeers:     peerSet,
 		Storage:        storage,
+		Index:          index,
+		Snapshots:      snapshots,
 		SelectStrategy: cfg.State.SelectStrategy,
+		MempoolLimits: mempool.Limits{
+			MaxTxs:        cfg.State.MempoolTxs,
+			MaxAccountTxs: cfg.State.MempoolAccountTxs,
+			MaxBytes:      cfg.State.MempoolBytes,
+		},
+		MempoolTTL:     cfg.State.MempoolTTL,
+		MempoolJournal: journal,
+		Consensus:      cfg.State.Consensus,
+		MiningWorkers:  cfg.State.MiningWorkers,
 		Genesis:        genesis,
 		EvHandler:      ev,
 	})
@@ -245,6 +322,7 @@ func run(log *zap.SugaredLogger) error {
 	privateMux := handlers.PrivateMux(handlers.MuxConfig{
 		Shutdown: shutdown,
 		Log:      log,
+		State:    state,
 	})
 
 	// Construct a server to service the requests against the mux.
@@ -300,3 +378,28 @@ func run(log *zap.SugaredLogger) error {
 
 	return nil
 }
+
+// newStorage constructs the storage option specified by configuration. The
+// codec determines how the blocks are encoded by the options that persist and
+// the depth is how many recent blocks keep their transactions when pruned.
+func newStorage(option string, dbPath string, codecName string, pruneDepth uint64) (database.Storage, error) {
+	if option == "memory" {
+		return memory.New()
+	}
+
+	cdc, err := codec.New(codecName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch option {
+	case "disk":
+		return disk.New(dbPath, cdc)
+	case "blocklog":
+		return blocklog.New(dbPath, cdc)
+	case "pruned":
+		return pruned.New(dbPath, cdc, pruneDepth)
+	}
+
+	return nil, fmt.Errorf("storage %q does not exist", option)
+}
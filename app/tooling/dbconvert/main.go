@@ -0,0 +1,98 @@
+// This program converts the blocks a node has written to disk into another
+// encoding or storage option. The source blocks are left untouched.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/blocklog"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/disk"
+)
+
+var (
+	fromPath    string
+	fromStorage string
+	fromCodec   string
+	toPath      string
+	toStorage   string
+	toCodec     string
+)
+
+func init() {
+	flag.StringVar(&fromPath, "from-path", "zblock/miner1/", "path to the blocks to convert")
+	flag.StringVar(&fromStorage, "from-storage", "disk", "storage option of the blocks to convert, disk or blocklog")
+	flag.StringVar(&fromCodec, "from-codec", "json", "encoding of the blocks to convert, json or rlp")
+	flag.StringVar(&toPath, "to-path", "", "path to write the converted blocks to")
+	flag.StringVar(&toStorage, "to-storage", "disk", "storage option for the converted blocks, disk or blocklog")
+	flag.StringVar(&toCodec, "to-codec", "rlp", "encoding for the converted blocks, json or rlp")
+}
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	if toPath == "" || filepath.Clean(toPath) == filepath.Clean(fromPath) {
+		return errors.New("a to-path different from the from-path is required")
+	}
+
+	from, err := newStorage(fromStorage, fromPath, fromCodec)
+	if err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	defer from.Close()
+
+	to, err := newStorage(toStorage, toPath, toCodec)
+	if err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+	defer to.Close()
+
+	if _, err := to.GetBlock(1); err == nil {
+		return fmt.Errorf("to: %s already holds blocks", toPath)
+	}
+
+	var count int
+	iter := from.ForEach()
+	for blockData, err := iter.Next(); !iter.Done(); blockData, err = iter.Next() {
+		if err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+
+		if err := to.Write(blockData); err != nil {
+			return fmt.Errorf("to: block %d: %w", blockData.Header.Number, err)
+		}
+		count++
+	}
+
+	fmt.Printf("converted %d blocks from %s to %s\n", count, fromPath, toPath)
+
+	return nil
+}
+
+// newStorage constructs the storage option with the specified codec.
+func newStorage(option string, dbPath string, codecName string) (database.Storage, error) {
+	cdc, err := codec.New(codecName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch option {
+	case "disk":
+		return disk.New(dbPath, cdc)
+	case "blocklog":
+		return blocklog.New(dbPath, cdc)
+	}
+
+	return nil, fmt.Errorf("storage %q does not exist", option)
+}
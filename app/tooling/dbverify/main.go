@@ -0,0 +1,63 @@
+// This program verifies the blocks a node has written to disk and can repair
+// the chain by truncating it back to the last good block.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/disk"
+)
+
+var (
+	dbPath    string
+	codecName string
+	repair    bool
+)
+
+func init() {
+	flag.StringVar(&dbPath, "db-path", "zblock/miner1/", "path to the blocks written by the node")
+	flag.StringVar(&codecName, "codec", "json", "encoding of the blocks, json or rlp")
+	flag.BoolVar(&repair, "repair", false, "truncate the chain back to the last good block")
+}
+
+func main() {
+	flag.Parse()
+
+	cdc, err := codec.New(codecName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage, err := disk.New(dbPath, cdc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	corrupt, err := storage.Verify()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(corrupt) == 0 {
+		fmt.Println("all blocks verified")
+		return
+	}
+
+	for _, c := range corrupt {
+		fmt.Println(c.Err)
+	}
+
+	if !repair {
+		log.Fatalf("%d corrupt blocks, run with -repair to truncate the chain", len(corrupt))
+	}
+
+	lastGood, err := storage.Repair()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("chain truncated to block %d\n", lastGood)
+}
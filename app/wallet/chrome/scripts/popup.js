@@ -380,16 +380,20 @@ function mempool() {
         type: "get",
         url: "http://localhost:8080/v1/tx/uncommitted/list/" + wallet.address,
         success: function (resp) {
+
+            // The pending and queued transactions are both waiting to be mined.
+            const trans = resp.pending.concat(resp.queued);
+
             var msg = "";
             var count = 0;
-            for (var i = 0; i < resp.length; i++) {
-                msg += JSON.stringify(resp[i], null, 2);
+            for (var i = 0; i < trans.length; i++) {
+                msg += JSON.stringify(trans[i], null, 2);
                 count++;
 
-                if (resp[i].from == wallet.address) {
+                if (trans[i].from == wallet.address) {
 
                     // Check the mempool for what the next nonce should be for this account.
-                    const txNonce = Number(resp[i].nonce);
+                    const txNonce = Number(trans[i].nonce);
                     if (txNonce >= nonce) {
                         nonce = txNonce + 1
                         document.getElementById("nextnonce").innerHTML = nonce;
@@ -397,7 +401,7 @@ function mempool() {
 
                     // Update the accounts balance.
                     const frombal = document.getElementById("frombal");
-                    const txValue = Number(resp[i].value);
+                    const txValue = Number(trans[i].value);
                     var balance = Number(frombal.innerHTML.replace(/\$|,/g, '').replace(" ARD", ""));
                     balance -= txValue;
                     frombal.innerHTML = formatter.format(balance) + " ARD";
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/ardanlabs/blockchain/business/sys/validate"
+	"github.com/ardanlabs/blockchain/business/web/errs"
 	v1Web "github.com/ardanlabs/blockchain/business/web/v1"
 	"github.com/ardanlabs/blockchain/foundation/web"
 	"go.uber.org/zap"
@@ -54,6 +55,13 @@ func Errors(log *zap.SugaredLogger) web.Middleware {
 					}
 					status = reqErr.Status
 
+				case errs.IsTrusted(err):
+					trsErr := errs.GetTrusted(err)
+					er = v1Web.ErrorResponse{
+						Error: trsErr.Error(),
+					}
+					status = trsErr.Status
+
 				default:
 					er = v1Web.ErrorResponse{
 						Error: http.StatusText(http.StatusInternalServerError),
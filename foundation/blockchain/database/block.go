@@ -2,27 +2,242 @@ package database
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
 )
 
 // ErrChainForked is returned from validateNextBlock if another node's chain
-// is two or more blocks ahead of ours.
+// is two or more blocks ahead of ours, or builds on a different parent.
 var ErrChainForked = errors.New("blockchain forked, start resync")
 
+// The set of different consensus protocols that can be used.
+const (
+	ConsensusPOW = "POW"
+	ConsensusPOA = "POA"
+)
+
+// Bounds on the difficulty when it is retargeted. A hash has a limited
+// number of hex digits that can be required to be zero.
+const (
+	minDifficulty       = 1
+	maxPrefixDifficulty = 17
+)
+
+// retargetFactor limits how much the difficulty changes in one retarget. With
+// the hex prefix each difficulty step multiplies the work by 16, so the time to
+// produce a window of blocks has to drift by this factor before it changes.
+// With a target the difficulty can't move by more than this factor.
+const retargetFactor = 4
+
+// maxBlockTimeDrift is how far ahead of this node's clock the timestamp of a
+// block can be. Ethereum uses the same bound. The retarget is calculated from
+// these timestamps, so without a bound a miner could skew the difficulty.
+const maxBlockTimeDrift = 15 * time.Second
+
+// maxTarget is the largest value a 256-bit hash can have. The target for a
+// difficulty is this value divided by the difficulty.
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// =============================================================================
+
+// Rules represents the consensus rules a block is validated against.
+type Rules struct {
+	Consensus       string        // Consensus protocol the chain is running, POW or POA.
+	Authorities     []AccountID   // POA: The ordered set of accounts allowed to produce blocks.
+	Difficulty      uint64        // POW: Difficulty of the first block.
+	TargetBlockTime time.Duration // POW: Time between blocks the difficulty is adjusted towards.
+	RetargetWindow  uint64        // POW: Number of blocks between difficulty adjustments.
+	POWTargetBlock  uint64        // POW: First block whose hash is compared against a target, 0 keeps the hex prefix.
+}
+
+// NewRules constructs the consensus rules for the specified consensus
+// protocol using the settings in the genesis file.
+func NewRules(consensus string, gen genesis.Genesis) (Rules, error) {
+	rules := Rules{
+		Consensus:       strings.ToUpper(consensus),
+		Difficulty:      gen.Difficulty,
+		TargetBlockTime: time.Duration(gen.TargetBlockTime) * time.Second,
+		RetargetWindow:  gen.RetargetWindow,
+		POWTargetBlock:  gen.POWTargetBlock,
+	}
+
+	switch rules.Consensus {
+	case ConsensusPOW:
+		if rules.TargetBlockTime > 0 && rules.RetargetWindow < 2 {
+			return Rules{}, errors.New("difficulty retargeting requires a retarget window of at least 2 blocks")
+		}
+	case ConsensusPOA:
+		if len(gen.Authorities) == 0 {
+			return Rules{}, errors.New("POA consensus requires authorities in genesis")
+		}
+		for _, authority := range gen.Authorities {
+			accountID, err := ToAccountID(authority)
+			if err != nil {
+				return Rules{}, fmt.Errorf("authority %q: %w", authority, err)
+			}
+			rules.Authorities = append(rules.Authorities, accountID)
+		}
+	default:
+		return Rules{}, fmt.Errorf("consensus %q does not exist", consensus)
+	}
+
+	return rules, nil
+}
+
+// ScheduledSigner returns the authority whose turn it is to produce the
+// block with the specified number. Authorities take turns in round-robin
+// order based on the block number starting with block 1.
+func (r Rules) ScheduledSigner(number uint64) (AccountID, error) {
+	if len(r.Authorities) == 0 || number == 0 {
+		return "", errors.New("no authority is scheduled")
+	}
+
+	return r.Authorities[(number-1)%uint64(len(r.Authorities))], nil
+}
+
+// IsRetargeting reports whether the difficulty is adjusted over time.
+func (r Rules) IsRetargeting() bool {
+	return r.TargetBlockTime > 0
+}
+
+// IsTargetBlock reports whether the block with the specified number is solved
+// by comparing its hash against a target instead of the legacy hex prefix.
+func (r Rules) IsTargetBlock(number uint64) bool {
+	return r.POWTargetBlock > 0 && number >= r.POWTargetBlock
+}
+
+// IsHashSolved checks the hash of the block with the specified number
+// complies with the POW rules for the specified difficulty.
+func (r Rules) IsHashSolved(number uint64, difficulty uint64, hash string) bool {
+	if r.IsTargetBlock(number) {
+		return isTargetSolved(difficulty, hash)
+	}
+
+	return isHashSolved(difficulty, hash)
+}
+
+// Work returns the amount of work it took to produce the block with the
+// specified number at the specified difficulty. Chains are compared by the
+// total work of their blocks and not by their length, since a longer chain
+// can be mined at a lower difficulty.
+func (r Rules) Work(number uint64, difficulty uint64) *big.Int {
+	switch {
+	case r.Consensus == ConsensusPOA:
+		return big.NewInt(1)
+	case r.IsTargetBlock(number):
+		return new(big.Int).SetUint64(difficulty)
+	default:
+		return new(big.Int).Lsh(big.NewInt(1), uint(4*difficulty))
+	}
+}
+
+// NextDifficulty returns the difficulty the block after the specified latest
+// block header must be mined at. When retargeting, the difficulty only
+// changes on the first block after each full window and is derived from the
+// timestamps in that window, so every node calculates the same value. The
+// function is used to read the header of the first block in the window.
+func (r Rules) NextDifficulty(latest BlockHeader, getHeader func(num uint64) (BlockHeader, error)) (uint64, error) {
+	number := latest.Number + 1
+
+	switch {
+	case number == 1:
+		return r.Difficulty, nil
+
+	// The chain moves from the hex prefix to a target with this block, so the
+	// difficulty is converted to keep the same amount of work.
+	case number > 1 && number == r.POWTargetBlock:
+		return targetDifficulty(latest.Difficulty), nil
+
+	case !r.IsRetargeting() || latest.Number%r.RetargetWindow != 0:
+		return latest.Difficulty, nil
+	}
+
+	first, err := getHeader(latest.Number - r.RetargetWindow + 1)
+	if err != nil {
+		return 0, fmt.Errorf("retarget window: %w", err)
+	}
+
+	return r.Retarget(number, latest.Difficulty, first.TimeStamp, latest.TimeStamp), nil
+}
+
+// Retarget calculates the difficulty for the block with the specified number
+// following a full retarget window. The first and last timestamps, in
+// milliseconds, are from the first and last blocks of that window.
+func (r Rules) Retarget(number uint64, difficulty uint64, firstTimeStamp uint64, lastTimeStamp uint64) uint64 {
+	var actual time.Duration
+	if lastTimeStamp > firstTimeStamp {
+		actual = time.Duration(lastTimeStamp-firstTimeStamp) * time.Millisecond
+	}
+
+	// The timestamps cover the intervals between the blocks in the window.
+	expected := time.Duration(r.RetargetWindow-1) * r.TargetBlockTime
+
+	// The hex prefix can only go up a step when the window was produced too
+	// fast and down a step when it was too slow.
+	if !r.IsTargetBlock(number) {
+		switch {
+		case actual*retargetFactor < expected && difficulty < maxPrefixDifficulty:
+			difficulty++
+		case actual > expected*retargetFactor && difficulty > minDifficulty:
+			difficulty--
+		}
+		return difficulty
+	}
+
+	// The work to find a hash under the target grows linearly with the
+	// difficulty, so it's scaled by how far off the window was.
+	switch {
+	case actual < expected/retargetFactor:
+		actual = expected / retargetFactor
+	case actual > expected*retargetFactor:
+		actual = expected * retargetFactor
+	}
+
+	next := new(big.Int).SetUint64(difficulty)
+	next.Mul(next, big.NewInt(int64(expected)))
+	next.Div(next, big.NewInt(int64(actual)))
+
+	switch {
+	case next.Cmp(big.NewInt(minDifficulty)) < 0:
+		return minDifficulty
+	case !next.IsUint64():
+		return math.MaxUint64
+	}
+
+	return next.Uint64()
+}
+
+// targetDifficulty converts a hex prefix difficulty into the target difficulty
+// requiring the same amount of work. Each hex digit is worth 16 times the work.
+func targetDifficulty(difficulty uint64) uint64 {
+	if difficulty >= 16 {
+		return math.MaxUint64
+	}
+
+	return 1 << (4 * difficulty)
+}
+
 // =============================================================================
 
 // BlockData represents what can be serialized to disk and over the network.
 type BlockData struct {
 	Hash   string      `json:"hash"`
 	Header BlockHeader `json:"block"`
+	V      *big.Int    `json:"v"` // Ethereum: Recovery identifier of the beneficiary's header signature.
+	R      *big.Int    `json:"r"` // Ethereum: First coordinate of the ECDSA signature.
+	S      *big.Int    `json:"s"` // Ethereum: Second coordinate of the ECDSA signature.
 	Trans  []BlockTx   `json:"trans"`
 }
 
@@ -31,6 +246,9 @@ func NewBlockData(block Block) BlockData {
 	blockData := BlockData{
 		Hash:   block.Hash(),
 		Header: block.Header,
+		V:      block.V,
+		R:      block.R,
+		S:      block.S,
 		Trans:  block.MerkleTree.Values(),
 	}
 
@@ -47,6 +265,9 @@ func ToBlock(blockData BlockData) (Block, error) {
 	block := Block{
 		Header:     blockData.Header,
 		MerkleTree: tree,
+		V:          blockData.V,
+		R:          blockData.R,
+		S:          blockData.S,
 	}
 
 	return block, nil
@@ -60,7 +281,7 @@ type BlockHeader struct {
 	PrevBlockHash string    `json:"prev_block_hash"` // Bitcoin: Hash of the previous block in the chain. 前一个区块的哈希值。
 	TimeStamp     uint64    `json:"timestamp"`       // Bitcoin: Time the block was mined. 区块被挖掘的时间。
 	BeneficiaryID AccountID `json:"beneficiary"`     // Ethereum: The account who is receiving fees and tips. 收取手续费和小费的账户。
-	Difficulty    uint16    `json:"difficulty"`      // Ethereum: Number of 0's needed to solve the hash solution. 解决哈希函数所需的前导零的数量。
+	Difficulty    uint64    `json:"difficulty"`      // Ethereum: How hard it is to solve the hash solution. 解决哈希函数的难度。
 	MiningReward  uint64    `json:"mining_reward"`   // Ethereum: The reward for mining this block. 挖掘此区块的奖励。
 	StateRoot     string    `json:"state_root"`      // Ethereum: Represents a hash of the accounts and their balances. 表示账户及其余额的哈希值。
 	TransRoot     string    `json:"trans_root"`      // Both: Represents the merkle tree root hash for the transactions in this block. 表示此区块中交易的默克尔树根哈希。
@@ -71,22 +292,66 @@ type BlockHeader struct {
 type Block struct {
 	Header     BlockHeader
 	MerkleTree *merkle.Tree[BlockTx]
+	V          *big.Int // Signature of the header by the beneficiary in [R|S|V] format.
+	R          *big.Int
+	S          *big.Int
 }
 
 // POWArgs represents the set of arguments required to run POW.
 type POWArgs struct {
 	BeneficiaryID AccountID                   // 受益者ID，指定接收挖矿奖励的账户ID
-	Difficulty    uint16                      // 难度，表示挖矿过程中要求的工作量难度
+	Difficulty    uint64                      // 难度，表示挖矿过程中要求的工作量难度
 	MiningReward  uint64                      // 挖矿奖励，表示成功挖到新区块后给予矿工的奖励数量
 	PrevBlock     Block                       // 前一个区块，包含了前一个区块的信息，用于构建新区块的前置条件
 	StateRoot     string                      // 状态根，表示当前区块链状态的根哈希值，用于构建新区块时的状态校验
 	Trans         []BlockTx                   // 交易列表，包含了新区块要包含的所有交易
+	Rules         Rules                       // 共识规则，决定如何检查哈希是否满足难度
+	Workers       int                         // 并行搜索随机数的 goroutine 数量
 	EvHandler     func(v string, args ...any) // 事件处理器，用于处理挖矿过程中的各种事件
 }
 
 // POW constructs a new Block and performs the work to find a nonce that
 // solves the cryptographic POW puzzel.
 func POW(ctx context.Context, args POWArgs) (Block, error) {
+	block, err := newBlock(args)
+	if err != nil {
+		return Block{}, err
+	}
+
+	// Peform the proof of work mining operation.
+	if err := block.performPOW(ctx, args.Rules, args.Workers, args.EvHandler); err != nil {
+		return Block{}, err
+	}
+
+	return block, nil
+}
+
+// POAArgs represents the set of arguments required to produce a block
+// under the proof of authority consensus.
+type POAArgs struct {
+	BeneficiaryID AccountID
+	MiningReward  uint64
+	PrevBlock     Block
+	StateRoot     string
+	Trans         []BlockTx
+}
+
+// POA constructs a new Block for the proof of authority consensus. There is
+// no nonce to search for, peers accept the block because the beneficiary is
+// the authority scheduled to produce this block number.
+func POA(args POAArgs) (Block, error) {
+	return newBlock(POWArgs{
+		BeneficiaryID: args.BeneficiaryID,
+		MiningReward:  args.MiningReward,
+		PrevBlock:     args.PrevBlock,
+		StateRoot:     args.StateRoot,
+		Trans:         args.Trans,
+	})
+}
+
+// newBlock constructs the next block in the chain with the specified
+// transactions. The nonce is left at zero.
+func newBlock(args POWArgs) (Block, error) {
 
 	// When mining the first block, the previous block's hash will be zero.
 	prevBlockHash := signature.ZeroHash
@@ -101,12 +366,19 @@ func POW(ctx context.Context, args POWArgs) (Block, error) {
 		return Block{}, err
 	}
 
+	// The timestamp has to be after the parent block's, even if the parent
+	// came from a node whose clock is ahead of ours.
+	timeStamp := uint64(time.Now().UTC().UnixMilli())
+	if timeStamp <= args.PrevBlock.Header.TimeStamp {
+		timeStamp = args.PrevBlock.Header.TimeStamp + 1
+	}
+
 	// Construct the block to be mined.
 	block := Block{
 		Header: BlockHeader{
 			Number:        args.PrevBlock.Header.Number + 1,
 			PrevBlockHash: prevBlockHash,
-			TimeStamp:     uint64(time.Now().UTC().UnixMilli()),
+			TimeStamp:     timeStamp,
 			BeneficiaryID: args.BeneficiaryID,
 			Difficulty:    args.Difficulty,
 			MiningReward:  args.MiningReward,
@@ -117,17 +389,13 @@ func POW(ctx context.Context, args POWArgs) (Block, error) {
 		MerkleTree: tree,
 	}
 
-	// Peform the proof of work mining operation.
-	if err := block.performPOW(ctx, args.EvHandler); err != nil {
-		return Block{}, err
-	}
-
 	return block, nil
 }
 
 // performPOW does the work of mining to find a valid hash for a specified
 // block. Pointer semantics are being used since a nonce is being discovered.
-func (b *Block) performPOW(ctx context.Context, ev func(v string, args ...any)) error {
+// The search is split across the specified number of worker goroutines.
+func (b *Block) performPOW(ctx context.Context, rules Rules, workers int, ev func(v string, args ...any)) error {
 	ev("database: PerformPOW: MINING: started")
 	defer ev("database: PerformPOW: MINING: completed")
 
@@ -136,44 +404,142 @@ func (b *Block) performPOW(ctx context.Context, ev func(v string, args ...any))
 		ev("database: PerformPOW: MINING: tx[%s]", tx)
 	}
 
-	// Choose a random starting point for the nonce. After this, the nonce
-	// will be incremented by 1 until a solution is found by us or another node.
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Choose a random starting point for the nonce. Each worker starts at its
+	// own offset from this point and steps by the number of workers, so no
+	// two workers ever hash the same nonce.
 	nBig, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
 	if err != nil {
 		return ctx.Err()
 	}
-	b.Header.Nonce = nBig.Uint64()
+	startNonce := nBig.Uint64()
+
+	ev("viewer: PerformPOW: MINING: running: workers[%d]", workers)
+
+	// The first worker to find a solution cancels the rest of the search.
+	powCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	search := powSearch{
+		rules:   rules,
+		step:    uint64(workers),
+		started: time.Now(),
+		solved:  make(chan BlockHeader, workers),
+		cancel:  cancel,
+		ev:      ev,
+	}
 
-	ev("viewer: PerformPOW: MINING: running")
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		header := b.Header
+		header.Nonce = startNonce + uint64(i)
 
-	// Loop until we or another node finds a solution for the next block.
+		go func() {
+			defer wg.Done()
+			search.run(powCtx, header)
+		}()
+	}
+	wg.Wait()
+
+	attempts := atomic.LoadUint64(&search.attempts)
+	hashRate := search.hashRate(attempts)
+
+	// Either a worker found the solution or the search was cancelled.
+	select {
+	case header := <-search.solved:
+		b.Header = header
+
+		ev("database: PerformPOW: MINING: SOLVED: prevBlk[%s]: newBlk[%s]", b.Header.PrevBlockHash, b.Hash())
+		ev("database: PerformPOW: MINING: attempts[%d]: hashrate[%d/s]", attempts, hashRate)
+
+		return nil
+
+	default:
+		ev("database: PerformPOW: MINING: CANCELLED: attempts[%d]: hashrate[%d/s]", attempts, hashRate)
+
+		return ctx.Err()
+	}
+}
+
+// =============================================================================
+
+// Each worker adds its attempts to the shared count in batches, and the
+// aggregate hash rate is reported every million attempts.
+const (
+	powReportAttempts = 10_000
+	powReportInterval = 1_000_000
+)
+
+// powSearch holds the state shared by the workers searching for a nonce.
+type powSearch struct {
+	rules    Rules
+	step     uint64
+	started  time.Time
+	attempts uint64
+	solved   chan BlockHeader
+	cancel   context.CancelFunc
+	ev       func(v string, args ...any)
+}
+
+// run searches for a nonce that solves the puzzle starting with the nonce
+// in the specified header, until a solution is found or the context is
+// cancelled.
+func (ps *powSearch) run(ctx context.Context, header BlockHeader) {
 	var attempts uint64
+	defer func() {
+		atomic.AddUint64(&ps.attempts, attempts)
+	}()
+
 	for {
 		attempts++
-		if attempts%1_000_000 == 0 {
-			ev("viewer: PerformPOW: MINING: running: attempts[%d]", attempts)
+		if attempts%powReportAttempts == 0 {
+			ps.report(atomic.AddUint64(&ps.attempts, attempts))
+			attempts = 0
 		}
 
-		// Did we timeout trying to solve the problem.
+		// Did we timeout trying to solve the problem or did another worker
+		// find the solution.
 		if ctx.Err() != nil {
-			ev("database: PerformPOW: MINING: CANCELLED")
-			return ctx.Err()
+			return
 		}
 
 		// Hash the block and check if we have solved the puzzle.
-		hash := b.Hash()
-		if !isHashSolved(b.Header.Difficulty, hash) {
-			b.Header.Nonce++
-			//记住这里是没找到合适的 他会回到上面for循环的开头 当contine之后
+		hash := Block{Header: header}.Hash()
+		if !ps.rules.IsHashSolved(header.Number, header.Difficulty, hash) {
+			header.Nonce += ps.step
 			continue
 		}
-		//执行到这里就代表找到了需要的hash
-		//if you have solution; go for that you do not need sb tell you. you should cancel then you cancel
-		ev("database: PerformPOW: MINING: SOLVED: prevBlk[%s]: newBlk[%s]", b.Header.PrevBlockHash, hash)
-		ev("database: PerformPOW: MINING: attempts[%d]", attempts)
 
-		return nil
+		// The channel has room for every worker so this never blocks.
+		ps.solved <- header
+		ps.cancel()
+
+		return
+	}
+}
+
+// report logs the aggregate hash rate each time the total number of
+// attempts across the workers crosses the report interval.
+func (ps *powSearch) report(total uint64) {
+	if total%powReportInterval >= powReportAttempts {
+		return
 	}
+
+	ps.ev("viewer: PerformPOW: MINING: running: attempts[%d]: hashrate[%d/s]", total, ps.hashRate(total))
+}
+
+// hashRate calculates the number of hashes per second across the workers.
+func (ps *powSearch) hashRate(attempts uint64) uint64 {
+	elapsed := time.Since(ps.started).Seconds()
+	if elapsed == 0 {
+		return 0
+	}
+
+	return uint64(float64(attempts) / elapsed)
 }
 
 // Hash returns the unique hash for the Block.
@@ -196,8 +562,49 @@ func (b Block) Hash() string {
 	return signature.Hash(b.Header)
 }
 
+// Sign uses the specified private key to sign the block header. The key
+// must belong to the beneficiary so peers can verify who produced the block.
+// The signature is not part of the header, so the block hash doesn't change.
+func (b *Block) Sign(privateKey *ecdsa.PrivateKey) error {
+	v, r, s, err := signature.Sign(b.Header, privateKey)
+	if err != nil {
+		return err
+	}
+
+	b.V = v
+	b.R = r
+	b.S = s
+
+	return nil
+}
+
+// VerifySignature checks the block header was signed by the beneficiary
+// claimed in the header.
+func (b Block) VerifySignature() error {
+	if b.V == nil || b.R == nil || b.S == nil {
+		return errors.New("block is not signed")
+	}
+
+	if err := signature.VerifySignature(b.V, b.R, b.S); err != nil {
+		return err
+	}
+
+	address, err := signature.FromAddress(b.Header, b.V, b.R, b.S)
+	if err != nil {
+		return err
+	}
+
+	if address != string(b.Header.BeneficiaryID) {
+		return fmt.Errorf("block signature doesn't match beneficiary, got %s, exp %s", address, b.Header.BeneficiaryID)
+	}
+
+	return nil
+}
+
 // ValidateBlock takes a block and validates it to be included into the blockchain.
-func (b Block) ValidateBlock(previousBlock Block, stateRoot string, evHandler func(v string, args ...any)) error {
+// The difficulty is the value the database expects the next block to be
+// mined at under POW.
+func (b Block) ValidateBlock(previousBlock Block, stateRoot string, difficulty uint64, rules Rules, evHandler func(v string, args ...any)) error {
 	evHandler("database: ValidateBlock: validate: blk[%d]: check: chain is not forked", b.Header.Number)
 
 	// The node who sent this block has a chain that is two or more blocks ahead
@@ -207,48 +614,55 @@ func (b Block) ValidateBlock(previousBlock Block, stateRoot string, evHandler fu
 		return ErrChainForked
 	}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: block difficulty is the same or greater than parent block difficulty", b.Header.Number)
+	evHandler("database: ValidateBlock: validate: blk[%d]: check: block number is the next number", b.Header.Number)
 
-	if b.Header.Difficulty < previousBlock.Header.Difficulty {
-		return fmt.Errorf("block difficulty is less than previous block difficulty, parent %d, block %d", previousBlock.Header.Difficulty, b.Header.Difficulty)
+	if b.Header.Number != nextNumber {
+		return fmt.Errorf("this block is not the next number, got %d, exp %d", b.Header.Number, nextNumber)
 	}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: block hash has been solved", b.Header.Number)
+	evHandler("database: ValidateBlock: validate: blk[%d]: check: block is signed by the beneficiary", b.Header.Number)
 
-	hash := b.Hash()
-	if !isHashSolved(b.Header.Difficulty, hash) {
-		return fmt.Errorf("%s invalid block hash", hash)
+	if err := b.VerifySignature(); err != nil {
+		return err
 	}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: block number is the next number", b.Header.Number)
+	switch rules.Consensus {
+	case ConsensusPOA:
+		evHandler("database: ValidateBlock: validate: blk[%d]: check: block was produced by the scheduled authority", b.Header.Number)
 
-	if b.Header.Number != nextNumber {
-		return fmt.Errorf("this block is not the next number, got %d, exp %d", b.Header.Number, nextNumber)
-	}
+		signer, err := rules.ScheduledSigner(b.Header.Number)
+		if err != nil {
+			return err
+		}
+		if b.Header.BeneficiaryID != signer {
+			return fmt.Errorf("block produced by wrong authority, got %s, exp %s", b.Header.BeneficiaryID, signer)
+		}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: parent hash does match parent block", b.Header.Number)
+	default:
+		evHandler("database: ValidateBlock: validate: blk[%d]: check: block difficulty matches the expected difficulty", b.Header.Number)
 
-	if b.Header.PrevBlockHash != previousBlock.Hash() {
-		return fmt.Errorf("parent block hash doesn't match our known parent, got %s, exp %s", b.Header.PrevBlockHash, previousBlock.Hash())
-	}
+		if b.Header.Difficulty != difficulty {
+			return fmt.Errorf("block difficulty is wrong, got %d, exp %d", b.Header.Difficulty, difficulty)
+		}
 
-	if previousBlock.Header.TimeStamp > 0 {
-		evHandler("database: ValidateBlock: validate: blk[%d]: check: block's timestamp is greater than parent block's timestamp", b.Header.Number)
+		evHandler("database: ValidateBlock: validate: blk[%d]: check: block hash has been solved", b.Header.Number)
 
-		parentTime := time.Unix(int64(previousBlock.Header.TimeStamp), 0)
-		blockTime := time.Unix(int64(b.Header.TimeStamp), 0)
-		if blockTime.Before(parentTime) {
-			return fmt.Errorf("block timestamp is before parent block, parent %s, block %s", parentTime, blockTime)
+		hash := b.Hash()
+		if !rules.IsHashSolved(b.Header.Number, b.Header.Difficulty, hash) {
+			return fmt.Errorf("%s invalid block hash", hash)
 		}
+	}
+
+	evHandler("database: ValidateBlock: validate: blk[%d]: check: parent hash does match parent block", b.Header.Number)
 
-		// This is a check that Ethereum does but we can't because we don't run all the time.
+	if b.Header.PrevBlockHash != previousBlock.Hash() {
+		return fmt.Errorf("parent block hash doesn't match our known parent, got %s, exp %s: %w", b.Header.PrevBlockHash, previousBlock.Hash(), ErrChainForked)
+	}
 
-		// evHandler("database: ValidateBlock: validate: blk[%d]: check: block is less than 15 minutes apart from parent block", b.Header.Number)
+	evHandler("database: ValidateBlock: validate: blk[%d]: check: block's timestamp is after parent block's timestamp and not in the future", b.Header.Number)
 
-		// dur := blockTime.Sub(parentTime)
-		// if dur.Seconds() > time.Duration(15*time.Second).Seconds() {
-		// 	return fmt.Errorf("block is older than 15 minutes, duration %v", dur)
-		// }
+	if err := ValidateTimeStamp(previousBlock.Header, b.Header, time.Now()); err != nil {
+		return err
 	}
 
 	evHandler("database: ValidateBlock: validate: blk[%d]: check: state root hash does match current database", b.Header.Number)
@@ -266,12 +680,31 @@ func (b Block) ValidateBlock(previousBlock Block, stateRoot string, evHandler fu
 	return nil
 }
 
+// ValidateTimeStamp checks the timestamp of the header is after the timestamp
+// of its parent header and not too far ahead of the specified current time.
+// There is no bound on how long after its parent a block can be, since our
+// nodes don't run all the time.
+func ValidateTimeStamp(parent BlockHeader, header BlockHeader, now time.Time) error {
+	blockTime := time.UnixMilli(int64(header.TimeStamp))
+
+	if header.TimeStamp <= parent.TimeStamp {
+		parentTime := time.UnixMilli(int64(parent.TimeStamp))
+		return fmt.Errorf("block timestamp is not after parent block, parent %s, block %s", parentTime, blockTime)
+	}
+
+	if blockTime.After(now.Add(maxBlockTimeDrift)) {
+		return fmt.Errorf("block timestamp is more than %v in the future, block %s", maxBlockTimeDrift, blockTime)
+	}
+
+	return nil
+}
+
 // isHashSolved checks the hash to make sure it complies with
 // the POW rules. We need to match a difficulty number of 0's.
-func isHashSolved(difficulty uint16, hash string) bool {
+func isHashSolved(difficulty uint64, hash string) bool {
 	const match = "0x00000000000000000"
 
-	if len(hash) != 66 {
+	if len(hash) != 66 || difficulty > maxPrefixDifficulty {
 		return false
 	}
 
@@ -279,3 +712,20 @@ func isHashSolved(difficulty uint16, hash string) bool {
 	difficulty += 2
 	return hash[:difficulty] == match[:difficulty]
 }
+
+// isTargetSolved checks the hash, treated as a 256-bit number, is not greater
+// than the target for the difficulty. Doubling the difficulty halves the
+// target, which doubles the expected number of hashes to find a solution.
+func isTargetSolved(difficulty uint64, hash string) bool {
+	if len(hash) != 66 || difficulty < minDifficulty {
+		return false
+	}
+
+	value, ok := new(big.Int).SetString(hash[2:], 16)
+	if !ok {
+		return false
+	}
+
+	target := new(big.Int).Div(maxTarget, new(big.Int).SetUint64(difficulty))
+	return value.Cmp(target) <= 0
+}
@@ -0,0 +1,144 @@
+package database_test
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// newHash constructs a hash from the specified leading hex digits with the
+// remaining digits set to the fill digit.
+func newHash(prefix string, fill string) string {
+	return "0x" + prefix + strings.Repeat(fill, 64-len(prefix))
+}
+
+// =============================================================================
+
+func Test_NextDifficulty(t *testing.T) {
+	pow := database.Rules{
+		Consensus:  database.ConsensusPOW,
+		Difficulty: 6,
+	}
+
+	retarget := pow
+	retarget.TargetBlockTime = 10 * time.Second
+	retarget.RetargetWindow = 4
+
+	target := retarget
+	target.POWTargetBlock = 1
+
+	conversion := retarget
+	conversion.POWTargetBlock = 5
+
+	poa := database.Rules{Consensus: database.ConsensusPOA}
+
+	table := []struct {
+		name       string
+		rules      database.Rules
+		latest     database.BlockHeader
+		first      uint64 // Timestamp of the first block in the window, 0 if it's missing.
+		difficulty uint64
+		wantErr    bool
+	}{
+		{name: "first block", rules: retarget, latest: database.BlockHeader{}, difficulty: 6},
+		{name: "poa", rules: poa, latest: database.BlockHeader{Number: 4}, difficulty: 0},
+		{name: "fixed difficulty", rules: pow, latest: database.BlockHeader{Number: 4, Difficulty: 6, TimeStamp: 2_000}, first: 1_000, difficulty: 6},
+		{name: "within the window", rules: retarget, latest: database.BlockHeader{Number: 5, Difficulty: 6, TimeStamp: 2_000}, first: 1_000, difficulty: 6},
+		{name: "prefix too fast", rules: retarget, latest: database.BlockHeader{Number: 4, Difficulty: 6, TimeStamp: 8_000}, first: 1_000, difficulty: 7},
+		{name: "prefix on time", rules: retarget, latest: database.BlockHeader{Number: 4, Difficulty: 6, TimeStamp: 31_000}, first: 1_000, difficulty: 6},
+		{name: "prefix too slow", rules: retarget, latest: database.BlockHeader{Number: 4, Difficulty: 6, TimeStamp: 122_000}, first: 1_000, difficulty: 5},
+		{name: "prefix at the maximum", rules: retarget, latest: database.BlockHeader{Number: 4, Difficulty: 17, TimeStamp: 2_000}, first: 1_000, difficulty: 17},
+		{name: "prefix at the minimum", rules: retarget, latest: database.BlockHeader{Number: 4, Difficulty: 1, TimeStamp: 122_000}, first: 1_000, difficulty: 1},
+		{name: "prefix converted to target", rules: conversion, latest: database.BlockHeader{Number: 4, Difficulty: 6, TimeStamp: 2_000}, first: 1_000, difficulty: 1 << 24},
+		{name: "prefix too large for target", rules: conversion, latest: database.BlockHeader{Number: 4, Difficulty: 16, TimeStamp: 2_000}, first: 1_000, difficulty: math.MaxUint64},
+		{name: "target on time", rules: target, latest: database.BlockHeader{Number: 8, Difficulty: 1_000, TimeStamp: 31_000}, first: 1_000, difficulty: 1_000},
+		{name: "target half speed", rules: target, latest: database.BlockHeader{Number: 8, Difficulty: 1_000, TimeStamp: 61_000}, first: 1_000, difficulty: 500},
+		{name: "target too fast", rules: target, latest: database.BlockHeader{Number: 8, Difficulty: 1_000, TimeStamp: 2_000}, first: 1_000, difficulty: 4_000},
+		{name: "target too slow", rules: target, latest: database.BlockHeader{Number: 8, Difficulty: 1_000, TimeStamp: 1_000_000}, first: 1_000, difficulty: 250},
+		{name: "target at the minimum", rules: target, latest: database.BlockHeader{Number: 8, Difficulty: 1, TimeStamp: 1_000_000}, first: 1_000, difficulty: 1},
+		{name: "window header missing", rules: retarget, latest: database.BlockHeader{Number: 4, Difficulty: 6, TimeStamp: 2_000}, wantErr: true},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only the header of the first block in the window is available.
+			getHeader := func(num uint64) (database.BlockHeader, error) {
+				if tt.first == 0 || num != tt.latest.Number-tt.rules.RetargetWindow+1 {
+					return database.BlockHeader{}, errors.New("header not found")
+				}
+				return database.BlockHeader{Number: num, TimeStamp: tt.first}, nil
+			}
+
+			difficulty, err := tt.rules.NextDifficulty(tt.latest, getHeader)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if difficulty != tt.difficulty {
+				t.Errorf("expected difficulty %d, got %d", tt.difficulty, difficulty)
+			}
+		})
+	}
+}
+
+func Test_IsHashSolved(t *testing.T) {
+	rules := database.Rules{
+		Consensus:      database.ConsensusPOW,
+		POWTargetBlock: 10,
+	}
+
+	table := []struct {
+		name       string
+		number     uint64
+		difficulty uint64
+		hash       string
+		solved     bool
+	}{
+		{name: "prefix solved", number: 9, difficulty: 2, hash: newHash("00", "f"), solved: true},
+		{name: "prefix not solved", number: 9, difficulty: 2, hash: newHash("0", "f")},
+		{name: "prefix over the maximum", number: 9, difficulty: 18, hash: newHash("", "0")},
+		{name: "target solved", number: 10, difficulty: 16, hash: newHash("0", "f"), solved: true},
+		{name: "target not solved", number: 10, difficulty: 16, hash: newHash("1", "0")},
+		{name: "target difficulty of 0", number: 10, difficulty: 0, hash: newHash("", "0")},
+		{name: "short hash", number: 10, difficulty: 1, hash: "0x00"},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.IsHashSolved(tt.number, tt.difficulty, tt.hash); got != tt.solved {
+				t.Errorf("expected solved %t, got %t", tt.solved, got)
+			}
+		})
+	}
+}
+
+func Test_ValidateTimeStamp(t *testing.T) {
+	now := time.UnixMilli(1_000_000)
+	parent := database.BlockHeader{Number: 1, TimeStamp: 900_000}
+
+	table := []struct {
+		name      string
+		timeStamp uint64
+		wantErr   bool
+	}{
+		{name: "after the parent", timeStamp: 900_001},
+		{name: "same as the parent", timeStamp: 900_000, wantErr: true},
+		{name: "before the parent", timeStamp: 800_000, wantErr: true},
+		{name: "within the drift", timeStamp: 1_015_000},
+		{name: "too far in the future", timeStamp: 1_015_001, wantErr: true},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			header := database.BlockHeader{Number: 2, TimeStamp: tt.timeStamp}
+
+			err := database.ValidateTimeStamp(parent, header, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %t, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -11,18 +11,69 @@ import (
 	"fmt"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
+	"math/big"
 	"sort"
 	"sync"
 )
 
+// ErrPruned is returned when the transactions of a block have been pruned
+// from storage. The header of the block is still available.
+var ErrPruned = errors.New("block has been pruned, only the header is available")
+
 // Storage interface represents the behavior required to be implemented by any
 // package providing support for reading and writing the blockchain.
 type Storage interface {
 	Write(blockData BlockData) error
 	GetBlock(num uint64) (BlockData, error)
 	ForEach() Iterator
+	ForEachFrom(num uint64) Iterator
 	Close() error
 	Reset() error
+	Truncate(num uint64) error
+}
+
+// Indexer interface represents the behavior required to be implemented by any
+// package providing support for looking up blocks and transactions by hash.
+type Indexer interface {
+	Add(block Block) error
+	Latest() uint64
+	BlockNumber(hash string) (uint64, error)
+	Transaction(hash string) (TxLocation, error)
+	Account(accountID AccountID) []TxLocation
+	Truncate(num uint64) error
+	Reset() error
+	Close() error
+}
+
+// Snapshotter interface represents the behavior required to be implemented by
+// any package providing support for saving and loading account snapshots.
+type Snapshotter interface {
+	Interval() uint64
+	Write(snapshot Snapshot) error
+	List() ([]uint64, error)
+	Read(num uint64) (Snapshot, error)
+	Truncate(num uint64) error
+	Reset() error
+}
+
+// Snapshot represents the accounts as they were before the block with the
+// specified number was applied. This is the state the block's StateRoot
+// commits to, so the snapshot can be checked against the chain. TotalWork is
+// the work of the blocks before the block.
+type Snapshot struct {
+	BlockNumber uint64    `json:"block_number"`
+	StateRoot   string    `json:"state_root"`
+	Accounts    []Account `json:"accounts"`
+	TotalWork   *big.Int  `json:"total_work,omitempty"`
+}
+
+// HeaderStorage interface represents the behavior of storage that keeps the
+// block headers separate from the transactions, so the transactions of old
+// blocks can be pruned once a snapshot covers them.
+type HeaderStorage interface {
+	GetHeader(num uint64) (BlockData, error)
+	ForEachHeader() Iterator
+	Prune(num uint64) error
 }
 
 // Iterator interface represents the behavior required to be implemented by any
@@ -32,25 +83,61 @@ type Iterator interface {
 	Done() bool
 }
 
+// maxUndoBlocks is the number of recent blocks that keep the account values
+// needed to roll them back during a chain reorganization.
+const maxUndoBlocks = 100
+
+// blockUndo holds the account values from before a block was applied. An
+// account that didn't exist before the block is recorded as nil.
+type blockUndo struct {
+	block    Block
+	accounts map[AccountID]*Account
+}
+
 // Database 需要一个数据库的类型 里面有互斥锁   基础配置 以及账户信息 是一个map  通过账户的id 也就是以太坊地址 去关联 完整的账户信息
 // Database manages data related to accounts who have transacted on the blockchain.
 type Database struct {
 	mu          sync.RWMutex
 	genesis     genesis.Genesis
+	rules       Rules
 	latestBlock Block
+	totalWork   *big.Int
 	accounts    map[AccountID]Account
+	undo        []blockUndo
 	storage     Storage
+	index       Indexer
+	snapshots   Snapshotter
+	evHandler   func(v string, args ...any)
 }
 
 // New 需要一个工程函数 去构建这个数据库 他是指针传递，意味着我们不想它复制太多，有一个实例即可
 // New evHandler 他是一个事件函数，因为我们不想把它跟这个工厂函数强绑定，通过这种方式，由调用者自己定义自己想要的事件处理函数（比如log 或者什么 自己自定义）这样更灵活
 // New constructs a new database and applies account genesis information and
-// reads/writes the blockchain database on disk if a dbPath is provided.
-func New(genesis genesis.Genesis, storage Storage, evHandler func(v string, args ...any)) (*Database, error) {
+// reads/writes the blockchain database on disk if a dbPath is provided. The
+// consensus protocol determines the rules used to validate each block. The
+// index is required and any blocks missing from it are added as the
+// blockchain is read. When snapshots are provided, only the blocks after the
+// latest snapshot that matches the chain are replayed. Snapshots are disabled
+// if nil.
+func New(genesis genesis.Genesis, storage Storage, index Indexer, snapshots Snapshotter, consensus string, evHandler func(v string, args ...any)) (*Database, error) {
+	if index == nil {
+		return nil, errors.New("an index is required")
+	}
+
+	rules, err := NewRules(consensus, genesis)
+	if err != nil {
+		return nil, err
+	}
+
 	db := Database{
-		genesis:  genesis,
-		accounts: make(map[AccountID]Account),
-		storage:  storage,
+		genesis:   genesis,
+		rules:     rules,
+		totalWork: big.NewInt(0),
+		accounts:  make(map[AccountID]Account),
+		storage:   storage,
+		index:     index,
+		snapshots: snapshots,
+		evHandler: evHandler,
 	}
 
 	// Update the database with account balance information from genesis.
@@ -62,26 +149,82 @@ func New(genesis genesis.Genesis, storage Storage, evHandler func(v string, args
 		db.accounts[accountID] = newAccount(accountID, balance)
 	}
 
-	// Read all the blocks from storage.
-	iter := db.ForEach()
+	// Start with the accounts from the latest snapshot that matches the chain.
+	from, err := db.loadSnapshot(evHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocks before the snapshot are only read to rebuild the index. Blocks
+	// that have been pruned are only indexed by hash.
+	for num := index.Latest() + 1; num < from; num++ {
+		block, err := db.GetBlock(num)
+		if errors.Is(err, ErrPruned) {
+			var header BlockHeader
+			if header, err = db.GetHeader(num); err == nil {
+				block = Block{Header: header}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := index.Add(block); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the blocks after the snapshot from storage.
+	iter := DatabaseIterator{iterator: storage.ForEachFrom(from)}
 	for block, err := iter.Next(); !iter.Done(); block, err = iter.Next() {
 		if err != nil {
 			return nil, err
 		}
 
+		// Calculate the difficulty this block had to be mined at.
+		difficulty, err := db.NextDifficulty()
+		if err != nil {
+			return nil, err
+		}
+
 		// Validate the block values and cryptographic audit trail.
-		if err := block.ValidateBlock(db.latestBlock, db.HashState(), evHandler); err != nil {
+		if err := block.ValidateBlock(db.latestBlock, db.HashState(), difficulty, db.rules, evHandler); err != nil {
 			return nil, err
 		}
 
+		// Snapshot the accounts if this block is due one.
+		if block.Header.Number > from {
+			db.writeSnapshot(block)
+		}
+
+		// Rebuild the index for any block it doesn't have or that doesn't
+		// match the block in storage.
+		if block.Header.Number <= index.Latest() {
+			if num, err := index.BlockNumber(block.Hash()); err != nil || num != block.Header.Number {
+				if err := index.Truncate(block.Header.Number - 1); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if block.Header.Number > index.Latest() {
+			if err := index.Add(block); err != nil {
+				return nil, err
+			}
+		}
+
+		// Update the current latest block.
+		db.UpdateLatestBlock(block)
+
 		// Update the database with the transaction information.
 		for _, tx := range block.MerkleTree.Values() {
 			db.ApplyTransaction(block, tx)
 		}
 		db.ApplyMiningReward(block)
+	}
 
-		// Update the current latest block.
-		db.latestBlock = block
+	// The index can't hold blocks that are no longer in storage.
+	if err := index.Truncate(db.latestBlock.Header.Number); err != nil {
+		return nil, err
 	}
 
 	return &db, nil
@@ -90,6 +233,7 @@ func New(genesis genesis.Genesis, storage Storage, evHandler func(v string, args
 // Close closes the open blocks database.
 func (db *Database) Close() {
 	db.storage.Close()
+	db.index.Close()
 }
 
 // Reset re-initializes the database back to the genesis state.
@@ -97,10 +241,24 @@ func (db *Database) Reset() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	db.storage.Reset()
+	if err := db.storage.Reset(); err != nil {
+		return err
+	}
+
+	if err := db.index.Reset(); err != nil {
+		return err
+	}
+
+	if db.snapshots != nil {
+		if err := db.snapshots.Reset(); err != nil {
+			return err
+		}
+	}
 
 	// Initializes the database back to the genesis information.
 	db.latestBlock = Block{}
+	db.totalWork = big.NewInt(0)
+	db.undo = nil
 	db.accounts = make(map[AccountID]Account)
 	for accountStr, balance := range db.genesis.Balances {
 		accountID, err := ToAccountID(accountStr)
@@ -114,6 +272,17 @@ func (db *Database) Reset() error {
 	return nil
 }
 
+// Rules returns the consensus rules blocks are validated against.
+func (db *Database) Rules() Rules {
+	return db.rules
+}
+
+// NextDifficulty returns the difficulty the next block in the chain must be
+// mined at.
+func (db *Database) NextDifficulty() (uint64, error) {
+	return db.rules.NextDifficulty(db.LatestBlock().Header, db.GetHeader)
+}
+
 // Remove deletes an account from the database.
 func (db *Database) Remove(accountID AccountID) {
 	db.mu.Lock()
@@ -242,12 +411,142 @@ func (db *Database) ApplyTransaction(block Block, tx BlockTx) error {
 	return nil
 }
 
-// UpdateLatestBlock provides safe access to update the latest block.
+// UpdateLatestBlock provides safe access to update the latest block. This
+// must be called before the block's transactions are applied since it records
+// the account values needed to roll the block back.
 func (db *Database) UpdateLatestBlock(block Block) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	undo := blockUndo{
+		block:    block,
+		accounts: make(map[AccountID]*Account),
+	}
+
+	record := func(accountID AccountID) {
+		if _, exists := undo.accounts[accountID]; exists {
+			return
+		}
+
+		var prior *Account
+		if account, exists := db.accounts[accountID]; exists {
+			prior = &account
+		}
+		undo.accounts[accountID] = prior
+	}
+
+	// Capture every account the block can change.
+	record(block.Header.BeneficiaryID)
+	for _, tx := range block.MerkleTree.Values() {
+		record(tx.FromID)
+		record(tx.ToID)
+	}
+
+	db.undo = append(db.undo, undo)
+	if len(db.undo) > maxUndoBlocks {
+		db.undo = db.undo[len(db.undo)-maxUndoBlocks:]
+	}
+
 	db.latestBlock = block
+	db.totalWork = new(big.Int).Add(db.totalWork, db.rules.Work(block.Header.Number, block.Header.Difficulty))
+}
+
+// TotalWork returns the total work of the blocks in the chain. Peers compare
+// this value to decide which chain to follow.
+func (db *Database) TotalWork() *big.Int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return new(big.Int).Set(db.totalWork)
+}
+
+// RollbackDepth returns the number of blocks that can be rolled back.
+func (db *Database) RollbackDepth() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return uint64(len(db.undo))
+}
+
+// Rollback undoes the blocks after the specified block number, restoring
+// the accounts and removing the blocks from storage. The blocks that were
+// removed are returned, latest block first.
+func (db *Database) Rollback(num uint64) ([]Block, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	latestNumber := db.latestBlock.Header.Number
+	if num >= latestNumber {
+		return nil, nil
+	}
+
+	if latestNumber-num > uint64(len(db.undo)) {
+		return nil, fmt.Errorf("can't roll back %d blocks, only %d blocks can be undone", latestNumber-num, len(db.undo))
+	}
+
+	// Locate the block that becomes the latest block.
+	latestBlock := Block{}
+	switch {
+	case num == 0:
+	case latestNumber-num < uint64(len(db.undo)):
+		latestBlock = db.undo[len(db.undo)-int(latestNumber-num)-1].block
+	default:
+		// The latest block only needs its header until the next block is
+		// written, since its transactions may have been pruned.
+		header, err := db.GetHeader(num)
+		if err != nil {
+			return nil, err
+		}
+		latestBlock = Block{Header: header}
+	}
+
+	// The snapshots of the removed blocks hold the accounts of the removed
+	// blocks and can't be loaded when the node starts.
+	if db.snapshots != nil {
+		if err := db.snapshots.Truncate(num); err != nil {
+			return nil, err
+		}
+	}
+
+	// The index is truncated first, since blocks missing from the index are
+	// added back from storage when the node starts.
+	if err := db.index.Truncate(num); err != nil {
+		return nil, err
+	}
+
+	// Storage can't hold a block the index doesn't know about.
+	if err := db.storage.Truncate(num); err != nil {
+		for i := len(db.undo) - int(latestNumber-num); i < len(db.undo); i++ {
+			if ierr := db.index.Add(db.undo[i].block); ierr != nil {
+				return nil, fmt.Errorf("%w, adding block %d back to the index: %s", err, db.undo[i].block.Header.Number, ierr)
+			}
+		}
+		return nil, err
+	}
+
+	// Restore the accounts starting with the latest block.
+	var blocks []Block
+	totalWork := new(big.Int).Set(db.totalWork)
+	for i := len(db.undo) - 1; i >= 0 && db.undo[i].block.Header.Number > num; i-- {
+		for accountID, account := range db.undo[i].accounts {
+			if account == nil {
+				delete(db.accounts, accountID)
+				continue
+			}
+			db.accounts[accountID] = *account
+		}
+
+		header := db.undo[i].block.Header
+		totalWork.Sub(totalWork, db.rules.Work(header.Number, header.Difficulty))
+
+		blocks = append(blocks, db.undo[i].block)
+		db.undo = db.undo[:i]
+	}
+
+	db.latestBlock = latestBlock
+	db.totalWork = totalWork
+
+	return blocks, nil
 }
 
 // LatestBlock returns the latest block.
@@ -258,9 +557,27 @@ func (db *Database) LatestBlock() Block {
 	return db.latestBlock
 }
 
-// Write adds a new block to the chain.
+// Write adds a new block to the chain and the index. This must be called
+// before the block is applied, so a snapshot taken for the block holds the
+// accounts its StateRoot commits to.
 func (db *Database) Write(block Block) error {
-	return db.storage.Write(NewBlockData(block))
+	if err := db.storage.Write(NewBlockData(block)); err != nil {
+		return err
+	}
+
+	// Storage can't hold a block the index doesn't know about.
+	if err := db.index.Add(block); err != nil {
+		if terr := db.storage.Truncate(block.Header.Number - 1); terr != nil {
+			return fmt.Errorf("%w, removing block %d from storage: %s", err, block.Header.Number, terr)
+		}
+		return err
+	}
+
+	// The snapshot is only taken once the block is stored, since it's only
+	// loaded for a block in storage.
+	db.writeSnapshot(block)
+
+	return nil
 }
 
 // ForEach returns an iterator to walk through all the blocks
@@ -280,6 +597,211 @@ func (db *Database) GetBlock(num uint64) (Block, error) {
 	return ToBlock(blockData)
 }
 
+// GetHeader searches the blockchain to locate and return the header of the
+// specified block by number. This works for blocks that have been pruned.
+func (db *Database) GetHeader(num uint64) (BlockHeader, error) {
+	blockData, err := db.GetSignedHeader(num)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	return blockData.Header, nil
+}
+
+// GetSignedHeader searches the blockchain to locate and return the header of
+// the specified block by number along with the beneficiary's signature. The
+// block data has no transactions. This works for blocks that have been pruned.
+func (db *Database) GetSignedHeader(num uint64) (BlockData, error) {
+	getHeader := db.storage.GetBlock
+	if hs, ok := db.storage.(HeaderStorage); ok {
+		getHeader = hs.GetHeader
+	}
+
+	blockData, err := getHeader(num)
+	if err != nil {
+		return BlockData{}, err
+	}
+	blockData.Trans = nil
+
+	return blockData, nil
+}
+
+// ForEachHeader returns an iterator to walk through the headers of all the
+// blocks starting with block number 1. This works for blocks that have been
+// pruned.
+func (db *Database) ForEachHeader() HeaderIterator {
+	if hs, ok := db.storage.(HeaderStorage); ok {
+		return HeaderIterator{iterator: hs.ForEachHeader()}
+	}
+
+	return HeaderIterator{iterator: db.storage.ForEach()}
+}
+
+// GetBlockByHash uses the index to locate and return the contents of the
+// specified block by hash.
+func (db *Database) GetBlockByHash(hash string) (Block, error) {
+	num, err := db.index.BlockNumber(hash)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return db.GetBlock(num)
+}
+
+// GetTransaction uses the index to locate and return the specified
+// transaction by hash along with where it's stored in the blockchain.
+func (db *Database) GetTransaction(hash string) (BlockTx, TxLocation, error) {
+	loc, err := db.index.Transaction(hash)
+	if err != nil {
+		return BlockTx{}, TxLocation{}, err
+	}
+
+	block, err := db.GetBlock(loc.BlockNumber)
+	if err != nil {
+		return BlockTx{}, TxLocation{}, err
+	}
+
+	trans := block.MerkleTree.Values()
+	if loc.Position >= len(trans) {
+		return BlockTx{}, TxLocation{}, fmt.Errorf("transaction %s is not in block %d", hash, loc.BlockNumber)
+	}
+
+	return trans[loc.Position], loc, nil
+}
+
+// GetAccountTransactions uses the index to return where the transactions
+// sent or received by the specified account are stored in the blockchain.
+func (db *Database) GetAccountTransactions(accountID AccountID) []TxLocation {
+	return db.index.Account(accountID)
+}
+
+// =============================================================================
+
+// writeSnapshot saves the current accounts if the specified block is due a
+// snapshot. A snapshot only saves replaying blocks when the node starts, so
+// a snapshot that can't be written or pruning that fails is only reported.
+func (db *Database) writeSnapshot(block Block) {
+	if db.snapshots == nil || block.Header.Number%db.snapshots.Interval() != 0 {
+		return
+	}
+
+	var accounts []Account
+	db.mu.RLock()
+	{
+		accounts = make([]Account, 0, len(db.accounts))
+		for _, account := range db.accounts {
+			accounts = append(accounts, account)
+		}
+	}
+	db.mu.RUnlock()
+
+	sort.Sort(byAccount(accounts))
+
+	snapshot := Snapshot{
+		BlockNumber: block.Header.Number,
+		StateRoot:   signature.Hash(accounts),
+		Accounts:    accounts,
+		TotalWork:   db.TotalWork(),
+	}
+
+	if err := db.snapshots.Write(snapshot); err != nil {
+		db.evHandler("database: writeSnapshot: blk[%d]: ERROR: %s", block.Header.Number, err)
+		return
+	}
+
+	// The transactions of the blocks the snapshot covers are no longer
+	// needed to rebuild the accounts.
+	if hs, ok := db.storage.(HeaderStorage); ok {
+		if err := hs.Prune(block.Header.Number - 1); err != nil {
+			db.evHandler("database: writeSnapshot: blk[%d]: prune: ERROR: %s", block.Header.Number, err)
+		}
+	}
+}
+
+// loadSnapshot replaces the accounts with the latest snapshot whose state
+// matches the StateRoot of its block in storage. It returns the number of
+// the first block that needs to be replayed.
+func (db *Database) loadSnapshot(evHandler func(v string, args ...any)) (uint64, error) {
+	if db.snapshots == nil {
+		return 1, nil
+	}
+
+	nums, err := db.snapshots.List()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, num := range nums {
+		snapshot, err := db.snapshots.Read(num)
+		if err == nil {
+			err = db.useSnapshot(snapshot)
+		}
+
+		if err != nil {
+			evHandler("database: loadSnapshot: block[%d]: SKIPPED: %s", num, err)
+			continue
+		}
+
+		evHandler("database: loadSnapshot: block[%d]: LOADED", num)
+		return num, nil
+	}
+
+	return 1, nil
+}
+
+// useSnapshot checks the snapshot against the chain and sets the accounts
+// and latest block to continue replaying the chain from the snapshot.
+func (db *Database) useSnapshot(snapshot Snapshot) error {
+	header, err := db.GetHeader(snapshot.BlockNumber)
+	if err != nil {
+		return err
+	}
+
+	accounts := make([]Account, len(snapshot.Accounts))
+	copy(accounts, snapshot.Accounts)
+	sort.Sort(byAccount(accounts))
+
+	hash := signature.Hash(accounts)
+	if hash != snapshot.StateRoot {
+		return errors.New("snapshot does not match its state root")
+	}
+	if hash != header.StateRoot {
+		return fmt.Errorf("snapshot state root %s does not match the block, exp %s", hash, header.StateRoot)
+	}
+
+	// The latest block only needs its header until the next block is
+	// replayed, since its transactions may have been pruned.
+	var latestBlock Block
+	if snapshot.BlockNumber > 1 {
+		if latestBlock.Header, err = db.GetHeader(snapshot.BlockNumber - 1); err != nil {
+			return err
+		}
+	}
+
+	// Snapshots written before the total work was recorded need it summed
+	// from the headers of the blocks before the snapshot.
+	totalWork := snapshot.TotalWork
+	if totalWork == nil {
+		totalWork = big.NewInt(0)
+		for num := uint64(1); num < snapshot.BlockNumber; num++ {
+			header, err := db.GetHeader(num)
+			if err != nil {
+				return err
+			}
+			totalWork.Add(totalWork, db.rules.Work(header.Number, header.Difficulty))
+		}
+	}
+
+	db.latestBlock = latestBlock
+	db.totalWork = new(big.Int).Set(totalWork)
+	db.accounts = make(map[AccountID]Account)
+	for _, account := range accounts {
+		db.accounts[account.AccountID] = account
+	}
+
+	return nil
+}
+
 // =============================================================================
 
 // DatabaseIterator provides support for iterating over the blocks in the
@@ -302,3 +824,26 @@ func (di *DatabaseIterator) Next() (Block, error) {
 func (di *DatabaseIterator) Done() bool {
 	return di.iterator.Done()
 }
+
+// =============================================================================
+
+// HeaderIterator provides support for iterating over the block headers in
+// the blockchain database using the configured storage option.
+type HeaderIterator struct {
+	iterator Iterator
+}
+
+// Next retrieves the next block header from storage.
+func (hi *HeaderIterator) Next() (BlockHeader, error) {
+	blockData, err := hi.iterator.Next()
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	return blockData.Header, nil
+}
+
+// Done returns the end of chain value.
+func (hi *HeaderIterator) Done() bool {
+	return hi.iterator.Done()
+}
@@ -0,0 +1,457 @@
+package database_test
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/index"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/snapshot"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/memory"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/pruned"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// to is the account receiving the transactions in the test blocks.
+const to = database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+// account represents a test account that can sign.
+type account struct {
+	key *ecdsa.PrivateKey
+	id  database.AccountID
+}
+
+// newAccount constructs an account with a new key.
+func newAccount(t *testing.T) account {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	return account{key: key, id: database.PublicKeyToAccountID(key.PublicKey)}
+}
+
+// chain represents a database under POA consensus with the single authority
+// that produces its blocks and the account that sends its transactions.
+type chain struct {
+	db           *database.Database
+	gen          genesis.Genesis
+	storage      database.Storage
+	snapshots    *snapshot.Snapshots // Nil if snapshots are disabled.
+	snapshotPath string
+	authority    account
+	sender       account
+	states       []string // State of the accounts after each block, genesis first.
+	events       []string // Events from the database since it was last opened.
+}
+
+// newChain constructs a database on the specified storage and index with a
+// sender that holds the genesis balance. A snapshot is taken every interval
+// of blocks, snapshots are disabled if the interval is 0.
+func newChain(t *testing.T, storage database.Storage, idx database.Indexer, interval uint64) *chain {
+	authority := newAccount(t)
+	sender := newAccount(t)
+
+	c := chain{
+		gen: genesis.Genesis{
+			ChainID:      1,
+			MiningReward: 10,
+			Authorities:  []string{string(authority.id)},
+			Balances:     map[string]uint64{string(sender.id): 1_000_000},
+		},
+		storage:   storage,
+		authority: authority,
+		sender:    sender,
+	}
+
+	if interval > 0 {
+		c.snapshotPath = t.TempDir()
+
+		snapshots, err := snapshot.New(c.snapshotPath, interval)
+		if err != nil {
+			t.Fatalf("constructing snapshots: %v", err)
+		}
+		c.snapshots = snapshots
+	}
+
+	c.open(t, idx)
+	c.states = []string{c.db.HashState()}
+
+	return &c
+}
+
+// open constructs the database over the chain's storage and snapshots with
+// the specified index, replaying the blocks in storage.
+func (c *chain) open(t *testing.T, idx database.Indexer) {
+	// A nil *snapshot.Snapshots would be a non-nil Snapshotter.
+	var snapshots database.Snapshotter
+	if c.snapshots != nil {
+		snapshots = c.snapshots
+	}
+
+	c.events = nil
+	ev := func(v string, args ...any) {
+		c.events = append(c.events, fmt.Sprintf(v, args...))
+	}
+
+	db, err := database.New(c.gen, c.storage, idx, snapshots, database.ConsensusPOA, ev)
+	if err != nil {
+		t.Fatalf("constructing database: %v", err)
+	}
+	c.db = db
+}
+
+// newBlock constructs and signs the next block with a single transaction.
+func (c *chain) newBlock(t *testing.T) database.Block {
+	latest := c.db.LatestBlock()
+
+	tx, err := database.NewTx(1, latest.Header.Number+1, c.sender.id, to, 100, 1, nil)
+	if err != nil {
+		t.Fatalf("constructing tx: %v", err)
+	}
+	signedTx, err := tx.Sign(c.sender.key)
+	if err != nil {
+		t.Fatalf("signing tx: %v", err)
+	}
+
+	block, err := database.POA(database.POAArgs{
+		BeneficiaryID: c.authority.id,
+		MiningReward:  10,
+		PrevBlock:     latest,
+		StateRoot:     c.db.HashState(),
+		Trans:         []database.BlockTx{database.NewBlockTx(signedTx, 1, 1)},
+	})
+	if err != nil {
+		t.Fatalf("constructing block: %v", err)
+	}
+	if err := block.Sign(c.authority.key); err != nil {
+		t.Fatalf("signing block: %v", err)
+	}
+
+	return block
+}
+
+// addBlocks produces the specified number of blocks and applies them the way
+// the state does.
+func (c *chain) addBlocks(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		block := c.newBlock(t)
+
+		if err := c.db.Write(block); err != nil {
+			t.Fatalf("writing block %d: %v", block.Header.Number, err)
+		}
+		c.db.UpdateLatestBlock(block)
+		for _, tx := range block.MerkleTree.Values() {
+			if err := c.db.ApplyTransaction(block, tx); err != nil {
+				t.Fatalf("applying tx: %v", err)
+			}
+		}
+		c.db.ApplyMiningReward(block)
+
+		c.states = append(c.states, c.db.HashState())
+	}
+}
+
+// snapshotNumbers returns the block numbers of the snapshots, latest first.
+func (c *chain) snapshotNumbers(t *testing.T) []uint64 {
+	nums, err := c.snapshots.List()
+	if err != nil {
+		t.Fatalf("listing snapshots: %v", err)
+	}
+
+	return nums
+}
+
+// newMemory constructs storage kept in memory.
+func newMemory(t *testing.T) *memory.Memory {
+	mem, err := memory.New()
+	if err != nil {
+		t.Fatalf("constructing storage: %v", err)
+	}
+
+	return mem
+}
+
+// newPruned constructs storage on disk that prunes the transactions of the
+// blocks more than the specified depth behind the latest snapshot.
+func newPruned(t *testing.T, depth uint64) *pruned.Pruned {
+	cdc, err := codec.New("json")
+	if err != nil {
+		t.Fatalf("constructing codec: %v", err)
+	}
+
+	p, err := pruned.New(t.TempDir(), cdc, depth)
+	if err != nil {
+		t.Fatalf("constructing storage: %v", err)
+	}
+
+	return p
+}
+
+// newIndex constructs an index kept in memory.
+func newIndex(t *testing.T) *index.Index {
+	idx, err := index.New("")
+	if err != nil {
+		t.Fatalf("constructing index: %v", err)
+	}
+
+	return idx
+}
+
+// faultStorage represents memory storage that can be set to fail.
+type faultStorage struct {
+	*memory.Memory
+	failWrite    bool
+	failTruncate bool
+}
+
+// Write fails without storing the block if set to fail.
+func (s *faultStorage) Write(blockData database.BlockData) error {
+	if s.failWrite {
+		return errors.New("write failed")
+	}
+
+	return s.Memory.Write(blockData)
+}
+
+// Truncate fails without removing any blocks if set to fail.
+func (s *faultStorage) Truncate(num uint64) error {
+	if s.failTruncate {
+		return errors.New("truncate failed")
+	}
+
+	return s.Memory.Truncate(num)
+}
+
+// faultIndex represents an index kept in memory that can be set to fail.
+type faultIndex struct {
+	*index.Index
+	failAdd bool
+}
+
+// Add fails without indexing the block if set to fail.
+func (idx *faultIndex) Add(block database.Block) error {
+	if idx.failAdd {
+		return errors.New("add failed")
+	}
+
+	return idx.Index.Add(block)
+}
+
+// =============================================================================
+
+func Test_Write(t *testing.T) {
+	table := []struct {
+		name      string
+		failWrite bool
+		failAdd   bool
+	}{
+		{name: "written"},
+		{name: "storage write fails", failWrite: true},
+		{name: "index add fails", failAdd: true},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := faultStorage{Memory: newMemory(t)}
+			idx := faultIndex{Index: newIndex(t)}
+
+			c := newChain(t, &storage, &idx, 2)
+			c.addBlocks(t, 1)
+
+			// The second block is due a snapshot.
+			block := c.newBlock(t)
+			storage.failWrite = tt.failWrite
+			idx.failAdd = tt.failAdd
+
+			written := !tt.failWrite && !tt.failAdd
+			if err := c.db.Write(block); (err == nil) != written {
+				t.Fatalf("expected written %t, got %v", written, err)
+			}
+
+			if _, err := c.db.GetBlock(2); (err == nil) != written {
+				t.Errorf("expected in storage %t, got %v", written, err)
+			}
+			if _, err := c.db.GetBlockByHash(block.Hash()); (err == nil) != written {
+				t.Errorf("expected in index %t, got %v", written, err)
+			}
+
+			// A snapshot is only taken for a block that was written.
+			nums := c.snapshotNumbers(t)
+			if snapshotted := len(nums) == 1 && nums[0] == 2; snapshotted != written {
+				t.Errorf("expected snapshot %t, got snapshots %v", written, nums)
+			}
+		})
+	}
+}
+
+func Test_LoadSnapshot(t *testing.T) {
+	table := []struct {
+		name     string
+		interval uint64
+		corrupt  func(t *testing.T, c *chain)
+		loaded   uint64 // Block number of the snapshot loaded, 0 for none.
+	}{
+		{name: "no snapshots"},
+		{name: "latest snapshot", interval: 2, loaded: 4},
+		{
+			name:     "unreadable snapshot",
+			interval: 2,
+			corrupt: func(t *testing.T, c *chain) {
+				if err := os.WriteFile(filepath.Join(c.snapshotPath, "4.json"), []byte("{"), 0600); err != nil {
+					t.Fatalf("writing snapshot: %v", err)
+				}
+			},
+			loaded: 2,
+		},
+		{
+			name:     "snapshot does not match the chain",
+			interval: 2,
+			corrupt: func(t *testing.T, c *chain) {
+				snap, err := c.snapshots.Read(4)
+				if err != nil {
+					t.Fatalf("reading snapshot: %v", err)
+				}
+				snap.Accounts[0].Balance++
+				snap.StateRoot = signature.Hash(snap.Accounts)
+				if err := c.snapshots.Write(snap); err != nil {
+					t.Fatalf("writing snapshot: %v", err)
+				}
+			},
+			loaded: 2,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChain(t, newMemory(t), newIndex(t), tt.interval)
+			c.addBlocks(t, 5)
+			totalWork := c.db.TotalWork()
+
+			if tt.corrupt != nil {
+				tt.corrupt(t, c)
+			}
+
+			c.open(t, newIndex(t))
+
+			var loaded uint64
+			for _, event := range c.events {
+				if strings.HasSuffix(event, "LOADED") {
+					fmt.Sscanf(event, "database: loadSnapshot: block[%d]", &loaded)
+				}
+			}
+			if loaded != tt.loaded {
+				t.Errorf("expected snapshot %d loaded, got %d: %v", tt.loaded, loaded, c.events)
+			}
+
+			// The blocks after the snapshot have to be replayed to the same state.
+			if got := c.db.LatestBlock().Header.Number; got != 5 {
+				t.Errorf("expected latest block 5, got %d", got)
+			}
+			if got := c.db.HashState(); got != c.states[5] {
+				t.Errorf("expected the state after block 5")
+			}
+			if got := c.db.TotalWork(); got.Cmp(totalWork) != 0 {
+				t.Errorf("expected total work %s, got %s", totalWork, got)
+			}
+		})
+	}
+}
+
+func Test_Rollback(t *testing.T) {
+	table := []struct {
+		name         string
+		blocks       int
+		num          uint64
+		pruneDepth   uint64 // Use pruned storage with this depth, 0 for memory.
+		failTruncate bool
+		wantErr      bool
+	}{
+		{name: "nothing to roll back", blocks: 3, num: 3},
+		{name: "one block", blocks: 3, num: 2},
+		{name: "to genesis", blocks: 3, num: 0},
+		{name: "every undo record", blocks: 102, num: 2},
+		{name: "past the undo records", blocks: 102, num: 1, wantErr: true},
+		{name: "storage truncate fails", blocks: 3, num: 1, failTruncate: true, wantErr: true},
+		{name: "every undo record pruned", blocks: 102, num: 2, pruneDepth: 5},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			fault := faultStorage{Memory: newMemory(t)}
+			var storage database.Storage = &fault
+			if tt.pruneDepth > 0 {
+				storage = newPruned(t, tt.pruneDepth)
+			}
+			idx := newIndex(t)
+
+			c := newChain(t, storage, idx, 2)
+			c.addBlocks(t, tt.blocks)
+
+			// Remember the blocks, since a failed rollback has to keep them.
+			var hashes []string
+			for num := uint64(1); num <= uint64(tt.blocks); num++ {
+				header, err := c.db.GetHeader(num)
+				if err != nil {
+					t.Fatalf("getting block %d: %v", num, err)
+				}
+				hashes = append(hashes, database.Block{Header: header}.Hash())
+			}
+
+			fault.failTruncate = tt.failTruncate
+
+			removed, err := c.db.Rollback(tt.num)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+
+			latest := uint64(tt.blocks)
+			if !tt.wantErr {
+				latest = tt.num
+			}
+
+			if got := c.db.LatestBlock().Header.Number; got != latest {
+				t.Errorf("expected latest block %d, got %d", latest, got)
+			}
+			if got := c.db.HashState(); got != c.states[latest] {
+				t.Errorf("expected the state after block %d", latest)
+			}
+			if got := len(removed); got != tt.blocks-int(latest) {
+				t.Errorf("expected %d removed blocks, got %d", tt.blocks-int(latest), got)
+			}
+			for i, block := range removed {
+				if exp := uint64(tt.blocks - i); block.Header.Number != exp {
+					t.Errorf("expected removed block %d, got %d", exp, block.Header.Number)
+				}
+			}
+
+			// Only the blocks that are kept can be found in storage and the index.
+			for i, hash := range hashes {
+				num := uint64(i + 1)
+				kept := num <= latest
+
+				if _, err := c.db.GetHeader(num); kept != (err == nil) {
+					t.Errorf("block %d: expected in storage %t, got %v", num, kept, err)
+				}
+				if _, err := idx.BlockNumber(hash); kept != (err == nil) {
+					t.Errorf("block %d: expected in index %t, got %v", num, kept, err)
+				}
+			}
+
+			// Snapshots of the removed blocks can't be loaded on startup.
+			for _, num := range c.snapshotNumbers(t) {
+				if num > latest {
+					t.Errorf("snapshot %d kept after rolling back to %d", num, latest)
+				}
+			}
+		})
+	}
+}
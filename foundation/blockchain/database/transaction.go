@@ -168,3 +168,11 @@ func (tx BlockTx) Equals(otherTx BlockTx) bool {
 	otherTxSig := signature.ToSignatureBytes(otherTx.V, otherTx.R, otherTx.S)
 	return tx.Nonce == otherTx.Nonce && bytes.Equal(txSig, otherTxSig)
 }
+
+// =============================================================================
+
+// TxLocation represents where a block transaction is stored in the blockchain.
+type TxLocation struct {
+	BlockNumber uint64 `json:"block_number"`
+	Position    int    `json:"position"`
+}
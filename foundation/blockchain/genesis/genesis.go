@@ -9,13 +9,17 @@ import (
 
 // Genesis represents the genesis file.
 type Genesis struct {
-	Date          time.Time         `json:"date"`
-	ChainID       uint16            `json:"chain_id"`        // The chain id represents an unique id for this running instance.
-	TransPerBlock uint16            `json:"trans_per_block"` // The maximum number of transactions that can be in a block.
-	Difficulty    uint16            `json:"difficulty"`      // How difficult it needs to be to solve the work problem.
-	MiningReward  uint64            `json:"mining_reward"`   // Reward for mining a block.
-	GasPrice      uint64            `json:"gas_price"`       // Fee paid for each transaction mined into a block.
-	Balances      map[string]uint64 `json:"balances"`
+	Date            time.Time         `json:"date"`
+	ChainID         uint16            `json:"chain_id"`          // The chain id represents an unique id for this running instance.
+	TransPerBlock   uint16            `json:"trans_per_block"`   // The maximum number of transactions that can be in a block.
+	Difficulty      uint64            `json:"difficulty"`        // How difficult it needs to be to solve the work problem.
+	TargetBlockTime uint64            `json:"target_block_time"` // POW: Seconds between blocks the difficulty is adjusted towards, 0 turns retargeting off.
+	RetargetWindow  uint64            `json:"retarget_window"`   // POW: Number of blocks between difficulty adjustments.
+	POWTargetBlock  uint64            `json:"pow_target_block"`  // POW: First block solved against a 256-bit target instead of a hex prefix, 0 turns it off.
+	MiningReward    uint64            `json:"mining_reward"`     // Reward for mining a block.
+	GasPrice        uint64            `json:"gas_price"`         // Fee paid for each transaction mined into a block.
+	Authorities     []string          `json:"authorities"`       // POA: The ordered set of accounts allowed to produce blocks.
+	Balances        map[string]uint64 `json:"balances"`
 }
 
 // =============================================================================
@@ -0,0 +1,314 @@
+// Package index maintains lookups of blocks and transactions by hash and of
+// the transactions for each account. The index is journaled to a file so it
+// survives a restart, and can always be rebuilt from the blocks in storage.
+package index
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
+)
+
+// ErrNotFound is returned when a hash isn't in the index.
+var ErrNotFound = errors.New("not found in index")
+
+// Index represents the lookups for the blocks in the blockchain. Each block
+// is journaled as a line of JSON. This implements the database.Indexer
+// interface.
+type Index struct {
+	mu       sync.RWMutex
+	path     string
+	file     *os.File
+	size     int64
+	entries  []entry
+	blocks   map[string]uint64
+	txs      map[string]database.TxLocation
+	accounts map[database.AccountID][]database.TxLocation
+}
+
+// entry represents what is journaled for each block.
+type entry struct {
+	Number uint64    `json:"number"`
+	Hash   string    `json:"hash"`
+	Trans  []txEntry `json:"trans"`
+	offset int64     // Where the entry starts in the journal.
+}
+
+// txEntry represents what is journaled for each transaction in a block.
+type txEntry struct {
+	Hash   string             `json:"hash"`
+	FromID database.AccountID `json:"from"`
+	ToID   database.AccountID `json:"to"`
+}
+
+// New constructs an Index value for use. The index is journaled to the file
+// at the specified path, or only kept in memory if the path is empty. Any
+// entries after a torn or invalid line in the journal are dropped, so they
+// are rebuilt from storage.
+func New(path string) (*Index, error) {
+	idx := Index{
+		path: path,
+	}
+	idx.clear()
+
+	if path == "" {
+		return &idx, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	idx.file = f
+
+	if err := idx.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &idx, nil
+}
+
+// Close closes the journal.
+func (idx *Index) Close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.file == nil {
+		return nil
+	}
+
+	return idx.file.Close()
+}
+
+// Add indexes the specified block. Blocks must be added in order. A block
+// whose transactions have been pruned has no merkle tree and is only indexed
+// by hash.
+func (idx *Index) Add(block database.Block) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if exp := uint64(len(idx.entries)) + 1; block.Header.Number != exp {
+		return fmt.Errorf("block %d is out of order for the index, exp %d", block.Header.Number, exp)
+	}
+
+	e := entry{
+		Number: block.Header.Number,
+		Hash:   block.Hash(),
+		offset: idx.size,
+	}
+
+	if block.MerkleTree != nil {
+		for _, tx := range block.MerkleTree.Values() {
+			e.Trans = append(e.Trans, txEntry{
+				Hash:   signature.Hash(tx),
+				FromID: tx.FromID,
+				ToID:   tx.ToID,
+			})
+		}
+	}
+
+	if idx.file != nil {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+
+		if _, err := idx.file.WriteAt(data, idx.size); err != nil {
+			return err
+		}
+		idx.size += int64(len(data))
+	}
+
+	idx.apply(e)
+
+	return nil
+}
+
+// Latest returns the number of the latest block in the index.
+func (idx *Index) Latest() uint64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return uint64(len(idx.entries))
+}
+
+// BlockNumber returns the number of the block with the specified hash.
+func (idx *Index) BlockNumber(hash string) (uint64, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	num, exists := idx.blocks[hash]
+	if !exists {
+		return 0, fmt.Errorf("block %s: %w", hash, ErrNotFound)
+	}
+
+	return num, nil
+}
+
+// Transaction returns where the transaction with the specified hash is
+// stored in the blockchain.
+func (idx *Index) Transaction(hash string) (database.TxLocation, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	loc, exists := idx.txs[hash]
+	if !exists {
+		return database.TxLocation{}, fmt.Errorf("transaction %s: %w", hash, ErrNotFound)
+	}
+
+	return loc, nil
+}
+
+// Account returns where the transactions sent or received by the specified
+// account are stored in the blockchain, in block order.
+func (idx *Index) Account(accountID database.AccountID) []database.TxLocation {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	locs := make([]database.TxLocation, len(idx.accounts[accountID]))
+	copy(locs, idx.accounts[accountID])
+
+	return locs
+}
+
+// Truncate removes all the blocks after the specified block number.
+func (idx *Index) Truncate(num uint64) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if num >= uint64(len(idx.entries)) {
+		return nil
+	}
+
+	offset := idx.entries[num].offset
+	if idx.file != nil {
+		if err := idx.file.Truncate(offset); err != nil {
+			return err
+		}
+		idx.size = offset
+	}
+
+	for i := len(idx.entries) - 1; i >= int(num); i-- {
+		e := idx.entries[i]
+
+		delete(idx.blocks, e.Hash)
+		for _, tx := range e.Trans {
+			delete(idx.txs, tx.Hash)
+		}
+	}
+	idx.entries = idx.entries[:num]
+
+	// The locations for an account were added in block order.
+	for accountID, locs := range idx.accounts {
+		n := len(locs)
+		for n > 0 && locs[n-1].BlockNumber > num {
+			n--
+		}
+
+		switch n {
+		case 0:
+			delete(idx.accounts, accountID)
+		default:
+			idx.accounts[accountID] = locs[:n]
+		}
+	}
+
+	return nil
+}
+
+// Reset will clear out the index.
+func (idx *Index) Reset() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.clear()
+
+	if idx.file == nil {
+		return nil
+	}
+
+	idx.file.Close()
+
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(idx.path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	idx.file = f
+	idx.size = 0
+
+	return nil
+}
+
+// =============================================================================
+
+// load reads the journal and applies the entries, truncating the journal
+// at the first line that can't be used.
+func (idx *Index) load() error {
+	r := bufio.NewReader(idx.file)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil || e.Number != uint64(len(idx.entries))+1 {
+			break
+		}
+
+		e.offset = idx.size
+		idx.apply(e)
+		idx.size += int64(len(line))
+	}
+
+	return idx.file.Truncate(idx.size)
+}
+
+// apply adds the entry to the lookups.
+func (idx *Index) apply(e entry) {
+	idx.entries = append(idx.entries, e)
+	idx.blocks[e.Hash] = e.Number
+
+	for i, tx := range e.Trans {
+		loc := database.TxLocation{
+			BlockNumber: e.Number,
+			Position:    i,
+		}
+
+		idx.txs[tx.Hash] = loc
+		idx.accounts[tx.FromID] = append(idx.accounts[tx.FromID], loc)
+		if tx.ToID != tx.FromID {
+			idx.accounts[tx.ToID] = append(idx.accounts[tx.ToID], loc)
+		}
+	}
+}
+
+// clear empties the lookups.
+func (idx *Index) clear() {
+	idx.entries = nil
+	idx.blocks = make(map[string]uint64)
+	idx.txs = make(map[string]database.TxLocation)
+	idx.accounts = make(map[database.AccountID][]database.TxLocation)
+}
@@ -0,0 +1,180 @@
+package index_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/index"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// to is the account receiving the transactions in the test blocks.
+const to = database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+// newBlocks constructs the specified number of blocks, each holding a single
+// transaction from the returned account.
+func newBlocks(t *testing.T, n int) ([]database.Block, database.AccountID) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	from := database.PublicKeyToAccountID(key.PublicKey)
+
+	blocks := make([]database.Block, n)
+	for i := range blocks {
+		tx, err := database.NewTx(1, uint64(i+1), from, to, 100, 1, nil)
+		if err != nil {
+			t.Fatalf("constructing tx: %v", err)
+		}
+		signedTx, err := tx.Sign(key)
+		if err != nil {
+			t.Fatalf("signing tx: %v", err)
+		}
+
+		tree, err := merkle.NewTree([]database.BlockTx{database.NewBlockTx(signedTx, 15, 1)})
+		if err != nil {
+			t.Fatalf("constructing tree: %v", err)
+		}
+
+		blocks[i] = database.Block{
+			Header:     database.BlockHeader{Number: uint64(i + 1), TimeStamp: uint64(1000 + i)},
+			MerkleTree: tree,
+		}
+	}
+
+	return blocks, from
+}
+
+// =============================================================================
+
+func Test_Index(t *testing.T) {
+	table := []struct {
+		name     string
+		truncate uint64 // Truncate to this block number, 0 for no truncate.
+		reopen   bool
+		corrupt  func(data []byte) []byte // Changes the journal before it's reopened.
+		latest   uint64
+	}{
+		{name: "add", latest: 3},
+		{name: "truncate", truncate: 1, latest: 1},
+		{name: "reload", reopen: true, latest: 3},
+		{name: "reload after truncate", truncate: 1, reopen: true, latest: 1},
+		{
+			name:    "torn line dropped",
+			reopen:  true,
+			corrupt: func(data []byte) []byte { return data[:len(data)-5] },
+			latest:  2,
+		},
+		{
+			name:   "out of order line dropped",
+			reopen: true,
+			corrupt: func(data []byte) []byte {
+				first := data[:bytes.IndexByte(data, '\n')+1]
+				return append(data, first...)
+			},
+			latest: 3,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "index.jsonl")
+			blocks, from := newBlocks(t, 5)
+
+			idx, err := index.New(path)
+			if err != nil {
+				t.Fatalf("constructing index: %v", err)
+			}
+
+			for _, block := range blocks[:3] {
+				if err := idx.Add(block); err != nil {
+					t.Fatalf("adding block %d: %v", block.Header.Number, err)
+				}
+			}
+
+			if tt.truncate > 0 {
+				if err := idx.Truncate(tt.truncate); err != nil {
+					t.Fatalf("truncating: %v", err)
+				}
+			}
+
+			if tt.reopen {
+				if err := idx.Close(); err != nil {
+					t.Fatalf("closing index: %v", err)
+				}
+
+				if tt.corrupt != nil {
+					data, err := os.ReadFile(path)
+					if err != nil {
+						t.Fatalf("reading journal: %v", err)
+					}
+					if err := os.WriteFile(path, tt.corrupt(data), 0600); err != nil {
+						t.Fatalf("writing journal: %v", err)
+					}
+				}
+
+				if idx, err = index.New(path); err != nil {
+					t.Fatalf("reopening index: %v", err)
+				}
+			}
+
+			if got := idx.Latest(); got != tt.latest {
+				t.Fatalf("expected latest block %d, got %d", tt.latest, got)
+			}
+
+			for _, block := range blocks {
+				num := block.Header.Number
+				held := num <= tt.latest
+
+				got, err := idx.BlockNumber(block.Hash())
+				if held && (err != nil || got != num) {
+					t.Errorf("block %d: expected in index, got %d, %v", num, got, err)
+				}
+				if !held && !errors.Is(err, index.ErrNotFound) {
+					t.Errorf("block %d: expected not found, got %v", num, err)
+				}
+
+				loc, err := idx.Transaction(signature.Hash(block.MerkleTree.Values()[0]))
+				if held && (err != nil || loc.BlockNumber != num || loc.Position != 0) {
+					t.Errorf("block %d: expected tx in index, got %+v, %v", num, loc, err)
+				}
+				if !held && !errors.Is(err, index.ErrNotFound) {
+					t.Errorf("block %d: expected tx not found, got %v", num, err)
+				}
+			}
+
+			for _, accountID := range []database.AccountID{from, to} {
+				if got := len(idx.Account(accountID)); got != int(tt.latest) {
+					t.Errorf("account %s: expected %d transactions, got %d", accountID, tt.latest, got)
+				}
+			}
+
+			// Blocks can only be added in order after the latest block.
+			if err := idx.Add(blocks[tt.latest+1]); err == nil {
+				t.Errorf("expected out of order block %d to be rejected", tt.latest+2)
+			}
+			if err := idx.Add(blocks[tt.latest]); err != nil {
+				t.Fatalf("adding block %d: %v", tt.latest+1, err)
+			}
+			if err := idx.Close(); err != nil {
+				t.Fatalf("closing index: %v", err)
+			}
+
+			idx, err = index.New(path)
+			if err != nil {
+				t.Fatalf("reopening index: %v", err)
+			}
+			defer idx.Close()
+
+			if got := idx.Latest(); got != tt.latest+1 {
+				t.Errorf("expected latest block %d after reopening, got %d", tt.latest+1, got)
+			}
+		})
+	}
+}
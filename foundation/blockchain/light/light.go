@@ -0,0 +1,263 @@
+// Package light implements a light client that follows the block headers
+// produced by a node. The headers are validated without the transactions, and
+// a transaction is proven to be in a block by checking a merkle proof against
+// the TransRoot of the block's header. This lets a wallet confirm its own
+// transactions without trusting what a node tells it.
+package light
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// syncBatchSize is the number of headers requested from the node at a time.
+const syncBatchSize = 100
+
+// maxRollback is the number of headers the client will roll back looking for
+// the block it shares with the node when the node's chain has forked.
+const maxRollback = 100
+
+// netTimeout is the amount of time to wait for the node to respond.
+const netTimeout = 5 * time.Second
+
+// Client represents a light client following the headers of a node.
+type Client struct {
+	mu      sync.RWMutex
+	host    string
+	rules   database.Rules
+	headers []database.BlockHeader // Verified headers, the block number is the index + 1.
+}
+
+// New constructs a light client that follows the headers of the node at the
+// specified host, such as http://localhost:8080. The genesis and consensus
+// protocol are provided by the caller, not the node, since the rules the
+// headers are validated against come from them.
+func New(host string, gen genesis.Genesis, consensus string) (*Client, error) {
+	rules, err := database.NewRules(consensus, gen)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Client{
+		host:  strings.TrimSuffix(host, "/"),
+		rules: rules,
+	}
+
+	return &c, nil
+}
+
+// Latest returns the latest verified header. The zero header is returned if
+// no headers have been synced.
+func (c *Client) Latest() database.BlockHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.headers) == 0 {
+		return database.BlockHeader{}
+	}
+
+	return c.headers[len(c.headers)-1]
+}
+
+// Header returns the verified header for the specified block number.
+func (c *Client) Header(num uint64) (database.BlockHeader, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.header(num)
+}
+
+// Sync requests the headers after the latest verified header from the node
+// in batches, validates them and adds them to the chain. If the node's chain
+// has forked from the headers already verified, the client rolls back to the
+// block it shares with the node. It returns the number of headers added.
+func (c *Client) Sync() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var added int
+	var rollback int
+
+	for {
+		from := uint64(len(c.headers)) + 1
+		to := from + syncBatchSize - 1
+
+		var headers []database.BlockData
+		url := fmt.Sprintf("%s/v1/headers/list/%d/%d", c.host, from, to)
+		if err := send(url, &headers); err != nil {
+			return added, err
+		}
+
+		n, err := c.add(headers)
+		added += n
+
+		switch {
+		case errors.Is(err, database.ErrChainForked):
+
+			// The node doesn't build on our latest header, so drop it and
+			// look for the block we share with the node.
+			if rollback == maxRollback || len(c.headers) == 0 {
+				return added, fmt.Errorf("no common block found with the node: %w", err)
+			}
+			c.headers = c.headers[:len(c.headers)-1]
+			rollback++
+
+		case err != nil:
+			return added, err
+
+		// A short batch means the node has no more headers.
+		case len(headers) < syncBatchSize:
+			return added, nil
+		}
+	}
+}
+
+// VerifyTransaction proves the transaction is in the specified block using
+// the proof and order returned by merkle.Tree.Proof. The proof is checked
+// against the TransRoot of the verified header, so the node that provided
+// the proof doesn't need to be trusted.
+func (c *Client) VerifyTransaction(num uint64, tx database.BlockTx, proof [][]byte, order []int64) error {
+	header, err := c.Header(num)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := tx.Hash()
+	if err != nil {
+		return err
+	}
+
+	root, err := hexutil.Decode(header.TransRoot)
+	if err != nil {
+		return fmt.Errorf("block %d: trans root: %w", num, err)
+	}
+
+	if err := merkle.VerifyProof(leaf, proof, order, root); err != nil {
+		return fmt.Errorf("transaction is not in block %d: %w", num, err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// add validates the headers in order and adds them to the chain. The headers
+// that are valid are kept when a later header is not.
+func (c *Client) add(headers []database.BlockData) (int, error) {
+	for i, blockData := range headers {
+		block := database.Block{
+			Header: blockData.Header,
+			V:      blockData.V,
+			R:      blockData.R,
+			S:      blockData.S,
+		}
+
+		if err := c.validate(block); err != nil {
+			return i, fmt.Errorf("header %d: %w", block.Header.Number, err)
+		}
+
+		c.headers = append(c.headers, block.Header)
+	}
+
+	return len(headers), nil
+}
+
+// validate checks the header is the next header in the chain, that it's
+// signed by its beneficiary and that it follows the consensus rules.
+func (c *Client) validate(block database.Block) error {
+	header := block.Header
+
+	var prev database.BlockHeader
+	if len(c.headers) > 0 {
+		prev = c.headers[len(c.headers)-1]
+	}
+
+	if header.Number != prev.Number+1 {
+		return fmt.Errorf("header is not the next number, got %d, exp %d", header.Number, prev.Number+1)
+	}
+
+	prevHash := database.Block{Header: prev}.Hash()
+	if header.PrevBlockHash != prevHash {
+		return fmt.Errorf("parent hash doesn't match our known parent, got %s, exp %s: %w", header.PrevBlockHash, prevHash, database.ErrChainForked)
+	}
+
+	if err := database.ValidateTimeStamp(prev, header, time.Now()); err != nil {
+		return err
+	}
+
+	if err := block.VerifySignature(); err != nil {
+		return err
+	}
+
+	switch c.rules.Consensus {
+	case database.ConsensusPOA:
+		signer, err := c.rules.ScheduledSigner(header.Number)
+		if err != nil {
+			return err
+		}
+		if header.BeneficiaryID != signer {
+			return fmt.Errorf("header produced by wrong authority, got %s, exp %s", header.BeneficiaryID, signer)
+		}
+
+	default:
+		difficulty, err := c.rules.NextDifficulty(prev, c.header)
+		if err != nil {
+			return err
+		}
+
+		if header.Difficulty != difficulty {
+			return fmt.Errorf("header difficulty is wrong, got %d, exp %d", header.Difficulty, difficulty)
+		}
+
+		hash := block.Hash()
+		if !c.rules.IsHashSolved(header.Number, header.Difficulty, hash) {
+			return fmt.Errorf("%s invalid header hash", hash)
+		}
+	}
+
+	return nil
+}
+
+// header returns the verified header for the specified block number. The
+// caller must hold the lock.
+func (c *Client) header(num uint64) (database.BlockHeader, error) {
+	if num == 0 || num > uint64(len(c.headers)) {
+		return database.BlockHeader{}, fmt.Errorf("header %d has not been verified", num)
+	}
+
+	return c.headers[num-1], nil
+}
+
+// send is a helper function to request data from the node.
+func send(url string, dataRecv any) error {
+	client := http.Client{
+		Timeout: netTimeout,
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(msg))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dataRecv)
+}
@@ -0,0 +1,279 @@
+package light_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/light"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// to is the account receiving the transactions in the test blocks.
+const to = database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+// node represents a node under POA consensus serving the headers of its
+// blocks to a light client.
+type node struct {
+	authority *ecdsa.PrivateKey
+	sender    *ecdsa.PrivateKey
+	nonce     uint64
+	blocks    []database.Block
+}
+
+// newNode constructs a node with a single authority and starts serving its
+// headers. It returns the node and the host the headers are served from.
+func newNode(t *testing.T) (*node, string) {
+	n := node{
+		authority: newKey(t),
+		sender:    newKey(t),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(n.headers))
+	t.Cleanup(srv.Close)
+
+	return &n, srv.URL
+}
+
+// genesis returns the genesis of the node's chain.
+func (n *node) genesis() genesis.Genesis {
+	return genesis.Genesis{
+		ChainID:     1,
+		Authorities: []string{string(database.PublicKeyToAccountID(n.authority.PublicKey))},
+	}
+}
+
+// extend adds the specified number of blocks signed by the key, each with a
+// different transaction.
+func (n *node) extend(t *testing.T, key *ecdsa.PrivateKey, count int) {
+	for i := 0; i < count; i++ {
+		var prev database.Block
+		if len(n.blocks) > 0 {
+			prev = n.blocks[len(n.blocks)-1]
+		}
+
+		n.nonce++
+		tx, err := database.NewTx(1, n.nonce, database.PublicKeyToAccountID(n.sender.PublicKey), to, 100, 1, nil)
+		if err != nil {
+			t.Fatalf("constructing tx: %v", err)
+		}
+		signedTx, err := tx.Sign(n.sender)
+		if err != nil {
+			t.Fatalf("signing tx: %v", err)
+		}
+
+		block, err := database.POA(database.POAArgs{
+			BeneficiaryID: database.PublicKeyToAccountID(key.PublicKey),
+			MiningReward:  10,
+			PrevBlock:     prev,
+			Trans:         []database.BlockTx{database.NewBlockTx(signedTx, 1, 1)},
+		})
+		if err != nil {
+			t.Fatalf("constructing block: %v", err)
+		}
+		if err := block.Sign(key); err != nil {
+			t.Fatalf("signing block: %v", err)
+		}
+
+		n.blocks = append(n.blocks, block)
+	}
+}
+
+// headers serves the headers of the requested range of blocks the way the
+// node's headers list route does.
+func (n *node) headers(w http.ResponseWriter, r *http.Request) {
+	var from, to uint64
+	if _, err := fmt.Sscanf(r.URL.Path, "/v1/headers/list/%d/%d", &from, &to); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	headers := []database.BlockData{}
+	for num := from; num <= to && num <= uint64(len(n.blocks)); num++ {
+		blockData := database.NewBlockData(n.blocks[num-1])
+		blockData.Trans = nil
+		headers = append(headers, blockData)
+	}
+
+	json.NewEncoder(w).Encode(headers)
+}
+
+// newKey constructs a new private key.
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	return key
+}
+
+// =============================================================================
+
+func Test_Sync(t *testing.T) {
+	table := []struct {
+		name    string
+		build   func(t *testing.T, n *node)
+		added   int
+		wantErr bool
+	}{
+		{
+			name:  "valid headers",
+			build: func(t *testing.T, n *node) { n.extend(t, n.authority, 5) },
+			added: 5,
+		},
+		{
+			name:  "more than a batch",
+			build: func(t *testing.T, n *node) { n.extend(t, n.authority, 150) },
+			added: 150,
+		},
+		{
+			name: "wrong authority",
+			build: func(t *testing.T, n *node) {
+				n.extend(t, n.authority, 2)
+				n.extend(t, newKey(t), 2)
+			},
+			added:   2,
+			wantErr: true,
+		},
+		{
+			name: "bad signature",
+			build: func(t *testing.T, n *node) {
+				n.extend(t, n.authority, 4)
+				n.blocks[2].Header.MiningReward++
+			},
+			added:   2,
+			wantErr: true,
+		},
+		{
+			name: "missing header",
+			build: func(t *testing.T, n *node) {
+				n.extend(t, n.authority, 4)
+				n.blocks = append(n.blocks[:2], n.blocks[3:]...)
+			},
+			added:   2,
+			wantErr: true,
+		},
+		{
+			name: "timestamp not after parent",
+			build: func(t *testing.T, n *node) {
+				n.extend(t, n.authority, 2)
+				n.blocks[1].Header.TimeStamp = n.blocks[0].Header.TimeStamp
+				if err := n.blocks[1].Sign(n.authority); err != nil {
+					t.Fatalf("signing block: %v", err)
+				}
+			},
+			added:   1,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			n, host := newNode(t)
+			tt.build(t, n)
+
+			client, err := light.New(host, n.genesis(), database.ConsensusPOA)
+			if err != nil {
+				t.Fatalf("constructing client: %v", err)
+			}
+
+			added, err := client.Sync()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if added != tt.added {
+				t.Errorf("expected %d headers added, got %d", tt.added, added)
+			}
+
+			// Only the headers that were valid are kept.
+			latest := client.Latest()
+			if latest.Number != uint64(tt.added) {
+				t.Fatalf("expected latest header %d, got %d", tt.added, latest.Number)
+			}
+			if tt.added > 0 && latest != n.blocks[tt.added-1].Header {
+				t.Errorf("expected the node's header %d", tt.added)
+			}
+			if _, err := client.Header(uint64(tt.added + 1)); err == nil {
+				t.Errorf("expected header %d not to be verified", tt.added+1)
+			}
+		})
+	}
+}
+
+func Test_SyncFork(t *testing.T) {
+	n, host := newNode(t)
+	n.extend(t, n.authority, 3)
+
+	client, err := light.New(host, n.genesis(), database.ConsensusPOA)
+	if err != nil {
+		t.Fatalf("constructing client: %v", err)
+	}
+	if _, err := client.Sync(); err != nil {
+		t.Fatalf("syncing: %v", err)
+	}
+
+	// The node reorganizes onto a longer chain that forks after block 1.
+	n.blocks = n.blocks[:1]
+	n.extend(t, n.authority, 4)
+
+	added, err := client.Sync()
+	if err != nil {
+		t.Fatalf("syncing the fork: %v", err)
+	}
+	if added != 4 {
+		t.Errorf("expected 4 headers added, got %d", added)
+	}
+
+	for _, block := range n.blocks {
+		header, err := client.Header(block.Header.Number)
+		if err != nil || header != block.Header {
+			t.Errorf("expected the node's header %d, got %v", block.Header.Number, err)
+		}
+	}
+}
+
+func Test_VerifyTransaction(t *testing.T) {
+	n, host := newNode(t)
+	n.extend(t, n.authority, 3)
+
+	client, err := light.New(host, n.genesis(), database.ConsensusPOA)
+	if err != nil {
+		t.Fatalf("constructing client: %v", err)
+	}
+	if _, err := client.Sync(); err != nil {
+		t.Fatalf("syncing: %v", err)
+	}
+
+	tx := n.blocks[1].MerkleTree.Values()[0]
+	proof, order, err := n.blocks[1].MerkleTree.Proof(tx)
+	if err != nil {
+		t.Fatalf("constructing proof: %v", err)
+	}
+
+	table := []struct {
+		name    string
+		num     uint64
+		tx      database.BlockTx
+		wantErr bool
+	}{
+		{name: "in the block", num: 2, tx: tx},
+		{name: "other block", num: 3, tx: tx, wantErr: true},
+		{name: "other transaction", num: 2, tx: n.blocks[2].MerkleTree.Values()[0], wantErr: true},
+		{name: "header not verified", num: 4, tx: tx, wantErr: true},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.VerifyTransaction(tt.num, tt.tx, proof, order)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %t, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package mempool
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// journalCompactMin is the number of entries the journal can grow past the
+// size of the pool before it's rewritten with just the transactions held.
+const journalCompactMin = 1000
+
+// tempSuffix is added to the name of the journal for the temporary file the
+// journal is rewritten to before it's renamed into place.
+const tempSuffix = ".tmp-*"
+
+// Journal represents a record of the transactions accepted into and removed
+// from the mempool. Each change is journaled as a line of JSON, so the
+// transactions can be reloaded when the node restarts. The journal is best
+// effort. Changes are not synced to disk, so the latest changes can be lost
+// if the machine crashes. A lost transaction can be submitted again and a
+// lost removal only reloads a transaction that is validated again.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries int // Number of entries in the journal.
+}
+
+// journalEntry represents what is journaled for each change to the mempool.
+// Either a transaction was accepted or the transaction with the account:nonce
+// key was removed.
+type journalEntry struct {
+	Tx     *database.BlockTx `json:"tx,omitempty"`
+	Remove string            `json:"remove,omitempty"`
+}
+
+// OpenJournal constructs a Journal value for use. The journal is kept in the
+// file at the specified path. Temporary files left behind by a crash in the
+// middle of a rewrite are removed.
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	names, err := filepath.Glob(path + tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{path: path, file: f}, nil
+}
+
+// Close closes the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
+
+// Load reads the journal and returns the transactions that were held in the
+// mempool, ordered by account and nonce. Any entries after a torn or invalid
+// line are dropped.
+func (j *Journal) Load() ([]database.BlockTx, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	pool := make(map[string]database.BlockTx)
+	var size int64
+	j.entries = 0
+
+	r := bufio.NewReader(j.file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			break
+		}
+
+		switch {
+		case e.Tx != nil:
+			key, err := mapKey(*e.Tx)
+			if err != nil {
+				return nil, err
+			}
+			pool[key] = *e.Tx
+
+		default:
+			delete(pool, e.Remove)
+		}
+
+		size += int64(len(line))
+		j.entries++
+	}
+
+	if err := j.file.Truncate(size); err != nil {
+		return nil, err
+	}
+
+	trans := make([]database.BlockTx, 0, len(pool))
+	for _, tx := range pool {
+		trans = append(trans, tx)
+	}
+
+	sort.Slice(trans, func(i, j int) bool {
+		if trans[i].FromID != trans[j].FromID {
+			return trans[i].FromID < trans[j].FromID
+		}
+		return trans[i].Nonce < trans[j].Nonce
+	})
+
+	return trans, nil
+}
+
+// =============================================================================
+
+// add records the transaction was accepted into the mempool.
+func (j *Journal) add(tx database.BlockTx) error {
+	return j.write(journalEntry{Tx: &tx})
+}
+
+// remove records the transaction with the specified key was removed from
+// the mempool.
+func (j *Journal) remove(key string) error {
+	return j.write(journalEntry{Remove: key})
+}
+
+// write appends the entry to the journal. The entry is not synced to disk.
+func (j *Journal) write(e journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	j.entries++
+
+	return nil
+}
+
+// needsCompact reports whether the journal holds enough entries for removed
+// transactions that it should be rewritten.
+func (j *Journal) needsCompact(held int) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.entries > 2*held+journalCompactMin
+}
+
+// rewrite atomically replaces the journal with an entry for each of the
+// specified transactions. The journal is created again if it was removed,
+// such as when it's kept with a blockchain that was reset.
+func (j *Journal) rewrite(trans []database.BlockTx) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+tempSuffix)
+	if err != nil {
+		return err
+	}
+
+	if err := func() error {
+		defer f.Close()
+
+		w := bufio.NewWriter(f)
+		for i := range trans {
+			data, err := json.Marshal(journalEntry{Tx: &trans[i]})
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append(data, '\n')); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return f.Sync()
+	}(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), j.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	j.file.Close()
+	j.file = file
+	j.entries = len(trans)
+
+	return nil
+}
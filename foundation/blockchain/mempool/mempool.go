@@ -1,20 +1,67 @@
 package mempool
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
 	"math"
+	"sort"
 	"strings"
 	"sync"
 )
 
+// ErrTxExists is returned when the exact same transaction, matching on the
+// account:nonce key and the signature, is already held in the mempool.
+var ErrTxExists = errors.New("transaction already exists in mempool")
+
+// ErrPoolFull is returned when the mempool is at one of its limits and the
+// transaction is the one that would be evicted to make room.
+var ErrPoolFull = errors.New("mempool is full")
+
+// maxRemovedTxs is the number of removed transactions the mempool remembers
+// so peers can't re-introduce transactions that were already mined.
+const maxRemovedTxs = 10_000
+
+// Limits represents the caps on the size of the mempool. A cap of 0 means
+// there is no limit.
+type Limits struct {
+	MaxTxs        int // Number of transactions held in the pool.
+	MaxAccountTxs int // Number of transactions held for a single account.
+	MaxBytes      int // Approximate number of bytes held in the pool.
+}
+
+// NonceFunc returns the nonce of the last transaction applied to the
+// specified account in the database.
+type NonceFunc func(accountID database.AccountID) uint64
+
+// Config represents the configuration required to construct a mempool.
+type Config struct {
+	Strategy  string                      // Sort strategy used to select transactions.
+	Limits    Limits                      // Zero values impose no limits.
+	Nonce     NonceFunc                   // Nil treats every transaction as pending.
+	Journal   *Journal                    // Nil keeps the mempool in memory only.
+	EvHandler func(v string, args ...any) // Reports journal errors that don't fail the operation.
+}
+
 // Mempool represents a cache of transactions organized by account:nonce.
+// Transactions that can be mined are in the pending lane. Transactions whose
+// account is missing an earlier nonce wait in the queued lane until the gap
+// is filled.
 type Mempool struct {
-	mu       sync.RWMutex
-	pool     map[string]database.BlockTx
-	selectFn selector.Func
+	mu           sync.RWMutex
+	pool         map[string]database.BlockTx
+	queued       map[string]struct{}
+	accounts     map[database.AccountID]int
+	bytes        int
+	limits       Limits
+	nonceFn      NonceFunc
+	journal      *Journal
+	evHandler    func(v string, args ...any)
+	removed      map[string]struct{}
+	removedOrder []string
+	selectFn     selector.Func
 }
 
 // New constructs a new mempool using the default sort strategy. 基础的new按照tip构建
@@ -24,14 +71,35 @@ func New() (*Mempool, error) {
 
 // NewWithStrategy constructs a new mempool with specified sort strategy. 高级的按照自定义
 func NewWithStrategy(strategy string) (*Mempool, error) {
-	selectFn, err := selector.Retrieve(strategy)
+	return NewWithConfig(Config{Strategy: strategy})
+}
+
+// NewWithConfig constructs a new mempool with the specified sort strategy
+// that holds no more transactions than the specified limits allow. The nonce
+// function is used to sort the transactions into the pending and queued lanes.
+func NewWithConfig(cfg Config) (*Mempool, error) {
+	selectFn, err := selector.Retrieve(cfg.Strategy)
 	if err != nil {
 		return nil, err
 	}
 
+	// Build a safe event handler function for use.
+	ev := func(v string, args ...any) {
+		if cfg.EvHandler != nil {
+			cfg.EvHandler(v, args...)
+		}
+	}
+
 	mp := Mempool{
-		pool:     make(map[string]database.BlockTx),
-		selectFn: selectFn,
+		pool:      make(map[string]database.BlockTx),
+		queued:    make(map[string]struct{}),
+		accounts:  make(map[database.AccountID]int),
+		limits:    cfg.Limits,
+		nonceFn:   cfg.Nonce,
+		journal:   cfg.Journal,
+		evHandler: ev,
+		removed:   make(map[string]struct{}),
+		selectFn:  selectFn,
 	}
 
 	return &mp, nil
@@ -45,7 +113,71 @@ func (mp *Mempool) Count() int {
 	return len(mp.pool)
 }
 
-// Upsert adds or replaces a transaction from the mempool.
+// PendingCount returns the current number of transactions in the pending
+// lane, which are the transactions that can be mined.
+func (mp *Mempool) PendingCount() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.pool) - len(mp.queued)
+}
+
+// Queued returns the transactions in the queued lane ordered by account and
+// nonce. These transactions can't be mined until the earlier nonces for the
+// account are received.
+func (mp *Mempool) Queued() []database.BlockTx {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	trans := make([]database.BlockTx, 0, len(mp.queued))
+	for key := range mp.queued {
+		trans = append(trans, mp.pool[key])
+	}
+
+	sort.Slice(trans, func(i, j int) bool {
+		if trans[i].FromID != trans[j].FromID {
+			return trans[i].FromID < trans[j].FromID
+		}
+		return trans[i].Nonce < trans[j].Nonce
+	})
+
+	return trans
+}
+
+// UpdateLanes sorts the transactions into the pending and queued lanes again.
+// This must be called after the nonces in the database change, so queued
+// transactions are promoted when a block fills the gap in their nonces.
+func (mp *Mempool) UpdateLanes() {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.updateLanes()
+}
+
+// AccountTxs returns the transactions held for the specified account in
+// nonce order.
+func (mp *Mempool) AccountTxs(accountID database.AccountID) []database.BlockTx {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	var trans []database.BlockTx
+	for _, tx := range mp.pool {
+		if tx.FromID == accountID {
+			trans = append(trans, tx)
+		}
+	}
+
+	sort.Slice(trans, func(i, j int) bool { return trans[i].Nonce < trans[j].Nonce })
+
+	return trans
+}
+
+// Upsert adds or replaces a transaction from the mempool. If the mempool is
+// at one of its limits, the transactions with the lowest tip are evicted to
+// make room, taking only the highest nonce of an account. An error matching
+// ErrPoolFull is returned if the transaction doesn't offer a higher tip than
+// the transactions that would be evicted, or if its account is at its limit
+// and the transaction's nonce is after the nonces held.
 func (mp *Mempool) Upsert(tx database.BlockTx) error {
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
@@ -56,27 +188,69 @@ func (mp *Mempool) Upsert(tx database.BlockTx) error {
 	// is met, then either the transaction that has the least return on investment
 	// or the oldest will be dropped from the pool to make room for new the transaction.
 
-	// For now, the Ardan blockchain in not imposing any limits.
+	// The Ardan blockchain limits the number of transactions, the number of
+	// transactions for each account and the bytes they take up. Only the
+	// highest nonce of an account can be dropped. Out of those, the lowest
+	// tip is dropped first and the oldest is dropped when tips are the same.
 	key, err := mapKey(tx)
 	if err != nil {
 		return err
 	}
 
+	// A transaction with a nonce that has already been used can't be mined.
+	if mp.nonceFn != nil && tx.Nonce <= mp.nonceFn(tx.FromID) {
+		return fmt.Errorf("nonce %d has already been used by account %s", tx.Nonce, tx.FromID)
+	}
+
 	// Ethereum requires a 10% bump in the tip to replace an existing
 	// transaction in the mempool and so do we. We want to limit users
 	// from this sort of behavior.
 	if etx, exists := mp.pool[key]; exists {
+		if etx.Equals(tx) {
+			return ErrTxExists
+		}
 		if tx.Tip < uint64(math.Round(float64(etx.Tip)*1.10)) {
 			return errors.New("replacing a transaction requires a 10% bump in the tip")
 		}
 	}
 
-	mp.pool[key] = tx
+	evict, err := mp.evictions(key, tx)
+	if err != nil {
+		return err
+	}
+
+	// The changes are journaled first, so the pool is left alone if they
+	// can't be.
+	if mp.journal != nil {
+		for _, evictKey := range evict {
+			if err := mp.journal.remove(evictKey); err != nil {
+				return err
+			}
+		}
+		if err := mp.journal.add(tx); err != nil {
+			return err
+		}
+	}
+
+	accountIDs := []database.AccountID{tx.FromID}
+	for _, evictKey := range evict {
+		accountIDs = append(accountIDs, mp.pool[evictKey].FromID)
+		mp.remove(evictKey)
+	}
+	mp.remove(key)
+	mp.add(key, tx)
+
+	mp.updateLanes(accountIDs...)
+
+	// The transaction is in the pool, so a journal that can't be compacted
+	// doesn't fail it.
+	mp.compactJournal()
 
 	return nil
 }
 
-// Delete removed a transaction from the mempool.
+// Delete removed a transaction from the mempool. The transaction is removed
+// even if the removal can't be journaled.
 func (mp *Mempool) Delete(tx database.BlockTx) error {
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
@@ -86,21 +260,109 @@ func (mp *Mempool) Delete(tx database.BlockTx) error {
 		return err
 	}
 
-	delete(mp.pool, key)
+	_, held := mp.pool[key]
+	mp.remove(key)
+	mp.updateLanes(tx.FromID)
+	mp.remember(key, tx)
+
+	if held {
+		mp.journalRemove(key)
+		mp.compactJournal()
+	}
 
 	return nil
 }
 
+// RecentlyRemoved reports whether this exact transaction, matching on the
+// account:nonce key and the signature, was recently removed from the mempool.
+func (mp *Mempool) RecentlyRemoved(tx database.BlockTx) bool {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	key, err := mapKey(tx)
+	if err != nil {
+		return false
+	}
+
+	_, exists := mp.removed[key+":"+tx.SignatureString()]
+	return exists
+}
+
+// Expire removes the transactions that entered the mempool before the
+// specified time in unix milliseconds. The removed transactions are returned
+// and remembered like deleted ones, so peers can't share them again.
+func (mp *Mempool) Expire(before uint64) []database.BlockTx {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var expired []database.BlockTx
+	var accountIDs []database.AccountID
+	for key, tx := range mp.pool {
+		if tx.TimeStamp >= before {
+			continue
+		}
+
+		mp.journalRemove(key)
+		mp.remove(key)
+		mp.remember(key, tx)
+		expired = append(expired, tx)
+		accountIDs = append(accountIDs, tx.FromID)
+	}
+
+	// Transactions after an expired nonce wait in the queue again.
+	if len(accountIDs) > 0 {
+		mp.updateLanes(accountIDs...)
+	}
+
+	mp.compactJournal()
+
+	return expired
+}
+
 // Truncate clears all the transactions from the pool.
 func (mp *Mempool) Truncate() {
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
 
 	mp.pool = make(map[string]database.BlockTx)
+	mp.queued = make(map[string]struct{})
+	mp.accounts = make(map[database.AccountID]int)
+	mp.bytes = 0
+
+	// A journal that can't be cleared is not lost for good. When the
+	// transactions are reloaded they are validated again.
+	if mp.journal != nil {
+		if err := mp.journal.rewrite(nil); err != nil {
+			mp.evHandler("mempool: Truncate: journal: ERROR: %s", err)
+		}
+	}
+}
+
+// CompactJournal rewrites the journal with just the transactions held in
+// the pool.
+func (mp *Mempool) CompactJournal() error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.journal == nil {
+		return nil
+	}
+
+	return mp.journal.rewrite(mp.held())
+}
+
+// Close closes the journal.
+func (mp *Mempool) Close() error {
+	if mp.journal == nil {
+		return nil
+	}
+
+	return mp.journal.Close()
 }
 
-// PickBest uses the configured sort strategy to return a set of transactions.
-// If 0 is passed, all transactions in the mempool will be returned.
+// PickBest uses the configured sort strategy to return a set of transactions
+// from the pending lane. If 0 is passed, all the pending transactions will be
+// returned.
 func (mp *Mempool) PickBest(howMany ...uint16) []database.BlockTx {
 	number := 0
 	if len(howMany) > 0 {
@@ -124,10 +386,13 @@ func (mp *Mempool) PickBest(howMany ...uint16) []database.BlockTx {
 	mp.mu.RLock()
 	{
 		if number == 0 {
-			number = len(mp.pool)
+			number = len(mp.pool) - len(mp.queued)
 		}
 
 		for key, tx := range mp.pool {
+			if _, queued := mp.queued[key]; queued {
+				continue
+			}
 			account := accountFromMapKey(key)
 			m[account] = append(m[account], tx)
 		}
@@ -141,6 +406,260 @@ func (mp *Mempool) PickBest(howMany ...uint16) []database.BlockTx {
 
 // =============================================================================
 
+// evictions returns the keys of the transactions that need to be evicted to
+// make room for the specified transaction. A transaction being replaced is
+// never evicted, since the new transaction takes its place. Only the highest
+// nonce held for an account is evicted, since evicting a lower nonce leaves
+// the later transactions of the account unable to be mined.
+func (mp *Mempool) evictions(key string, tx database.BlockTx) ([]string, error) {
+	count := len(mp.pool) + 1
+	accountCount := mp.accounts[tx.FromID] + 1
+	bytes := mp.bytes + txSize(tx)
+
+	if etx, exists := mp.pool[key]; exists {
+		count--
+		accountCount--
+		bytes -= txSize(etx)
+	}
+
+	skip := map[string]bool{key: true}
+	var evict []string
+
+	for {
+		var reason string
+		var victimKey string
+		var victim database.BlockTx
+		var found bool
+
+		switch {
+		case mp.limits.MaxAccountTxs > 0 && accountCount > mp.limits.MaxAccountTxs:
+			reason = fmt.Sprintf("account %s is limited to %d transactions", tx.FromID, mp.limits.MaxAccountTxs)
+
+			// The account makes room for a lower nonce by dropping its
+			// highest nonce, whatever the tips are.
+			victimKey, victim, found = mp.tail(tx.FromID, skip)
+			if !found || victim.Nonce < tx.Nonce {
+				return nil, fmt.Errorf("%w: %s: nonce %d is after the nonces held", ErrPoolFull, reason, tx.Nonce)
+			}
+
+		case mp.limits.MaxTxs > 0 && count > mp.limits.MaxTxs:
+			reason = fmt.Sprintf("limited to %d transactions", mp.limits.MaxTxs)
+
+		case mp.limits.MaxBytes > 0 && bytes > mp.limits.MaxBytes:
+			reason = fmt.Sprintf("limited to %d bytes", mp.limits.MaxBytes)
+
+		default:
+			return evict, nil
+		}
+
+		// The new transaction has to offer a higher tip than the transaction
+		// it pushes out of the pool.
+		if victimKey == "" {
+			victimKey, victim, found = mp.lowestTip(tx, skip)
+			if !found || tx.Tip <= victim.Tip {
+				return nil, fmt.Errorf("%w: %s: tip %d is too low to evict another transaction", ErrPoolFull, reason, tx.Tip)
+			}
+		}
+
+		skip[victimKey] = true
+		evict = append(evict, victimKey)
+
+		count--
+		bytes -= txSize(victim)
+		if victim.FromID == tx.FromID {
+			accountCount--
+		}
+	}
+}
+
+// lowestTip returns the transaction with the lowest tip out of the highest
+// nonce held for each account, picking the oldest when tips are the same.
+// The account of the specified transaction is only considered when it holds
+// a nonce after the transaction's nonce.
+func (mp *Mempool) lowestTip(tx database.BlockTx, skip map[string]bool) (string, database.BlockTx, bool) {
+	tails := make(map[database.AccountID]string)
+	for key, ptx := range mp.pool {
+		if skip[key] {
+			continue
+		}
+
+		if tailKey, exists := tails[ptx.FromID]; !exists || ptx.Nonce > mp.pool[tailKey].Nonce {
+			tails[ptx.FromID] = key
+		}
+	}
+
+	var lowKey string
+	var low database.BlockTx
+	var found bool
+
+	for _, key := range tails {
+		ptx := mp.pool[key]
+		if ptx.FromID == tx.FromID && ptx.Nonce < tx.Nonce {
+			continue
+		}
+
+		if !found || ptx.Tip < low.Tip || (ptx.Tip == low.Tip && ptx.TimeStamp < low.TimeStamp) {
+			lowKey, low, found = key, ptx, true
+		}
+	}
+
+	return lowKey, low, found
+}
+
+// tail returns the transaction with the highest nonce held for the specified
+// account.
+func (mp *Mempool) tail(account database.AccountID, skip map[string]bool) (string, database.BlockTx, bool) {
+	var tailKey string
+	var tail database.BlockTx
+	var found bool
+
+	for key, tx := range mp.pool {
+		if skip[key] || tx.FromID != account {
+			continue
+		}
+
+		if !found || tx.Nonce > tail.Nonce {
+			tailKey, tail, found = key, tx, true
+		}
+	}
+
+	return tailKey, tail, found
+}
+
+// add puts the transaction in the pool and updates the size of the pool.
+func (mp *Mempool) add(key string, tx database.BlockTx) {
+	mp.pool[key] = tx
+	mp.accounts[tx.FromID]++
+	mp.bytes += txSize(tx)
+}
+
+// remove takes the transaction out of the pool if it exists and updates
+// the size of the pool.
+func (mp *Mempool) remove(key string) {
+	tx, exists := mp.pool[key]
+	if !exists {
+		return
+	}
+
+	delete(mp.pool, key)
+	delete(mp.queued, key)
+	mp.bytes -= txSize(tx)
+	mp.accounts[tx.FromID]--
+	if mp.accounts[tx.FromID] == 0 {
+		delete(mp.accounts, tx.FromID)
+	}
+}
+
+// remember records the transaction was removed so it can be identified if a
+// peer shares it again. Only the most recent maxRemovedTxs are kept.
+func (mp *Mempool) remember(key string, tx database.BlockTx) {
+	removedKey := key + ":" + tx.SignatureString()
+	if _, exists := mp.removed[removedKey]; exists {
+		return
+	}
+
+	mp.removed[removedKey] = struct{}{}
+	mp.removedOrder = append(mp.removedOrder, removedKey)
+
+	if len(mp.removedOrder) > maxRemovedTxs {
+		delete(mp.removed, mp.removedOrder[0])
+		mp.removedOrder = mp.removedOrder[1:]
+	}
+}
+
+// updateLanes sorts the transactions of the specified accounts into the
+// pending and queued lanes. A transaction is pending when the pool holds
+// every nonce between the account's nonce in the database and its own.
+// Transactions with a nonce that has already been used are dropped, since
+// they would fail when mined. If no accounts are specified, every account in
+// the pool is sorted.
+func (mp *Mempool) updateLanes(accountIDs ...database.AccountID) {
+	if mp.nonceFn == nil {
+		return
+	}
+
+	include := make(map[database.AccountID]bool)
+	for _, accountID := range accountIDs {
+		include[accountID] = true
+	}
+
+	m := make(map[database.AccountID][]string)
+	for key, tx := range mp.pool {
+		if len(include) == 0 || include[tx.FromID] {
+			m[tx.FromID] = append(m[tx.FromID], key)
+		}
+	}
+
+	for accountID, keys := range m {
+		sort.Slice(keys, func(i, j int) bool { return mp.pool[keys[i]].Nonce < mp.pool[keys[j]].Nonce })
+
+		nextNonce := mp.nonceFn(accountID) + 1
+		for _, key := range keys {
+			nonce := mp.pool[key].Nonce
+			switch {
+			case nonce < nextNonce:
+				mp.journalRemove(key)
+				mp.remove(key)
+
+			case nonce > nextNonce:
+				mp.queued[key] = struct{}{}
+
+			default:
+				delete(mp.queued, key)
+				nextNonce++
+			}
+		}
+	}
+}
+
+// journalRemove records the transaction with the specified key was removed
+// from the pool. A removal that can't be journaled is not lost for good,
+// since a reloaded transaction is validated and expired again, so the error
+// is only reported.
+func (mp *Mempool) journalRemove(key string) {
+	if mp.journal == nil {
+		return
+	}
+
+	if err := mp.journal.remove(key); err != nil {
+		mp.evHandler("mempool: journalRemove: tx[%s]: ERROR: %s", key, err)
+	}
+}
+
+// compactJournal rewrites the journal once it holds enough entries for
+// removed transactions. A journal that can't be compacted is still correct,
+// so the error is only reported.
+func (mp *Mempool) compactJournal() {
+	if mp.journal == nil || !mp.journal.needsCompact(len(mp.pool)) {
+		return
+	}
+
+	if err := mp.journal.rewrite(mp.held()); err != nil {
+		mp.evHandler("mempool: compactJournal: ERROR: %s", err)
+	}
+}
+
+// held returns a copy of the transactions held in the pool.
+func (mp *Mempool) held() []database.BlockTx {
+	trans := make([]database.BlockTx, 0, len(mp.pool))
+	for _, tx := range mp.pool {
+		trans = append(trans, tx)
+	}
+
+	return trans
+}
+
+// txSize approximates the number of bytes the transaction takes up by the
+// size of its encoding.
+func txSize(tx database.BlockTx) int {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return 0
+	}
+
+	return len(data)
+}
+
 // mapKey is used to generate the map key.
 func mapKey(tx database.BlockTx) (string, error) {
 	return fmt.Sprintf("%s:%d", tx.FromID, tx.Nonce), nil
@@ -0,0 +1,443 @@
+package mempool_test
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// account represents a test account that can sign transactions.
+type account struct {
+	key *ecdsa.PrivateKey
+	id  database.AccountID
+}
+
+// newAccounts constructs the specified number of accounts with new keys.
+func newAccounts(t *testing.T, n int) []account {
+	accounts := make([]account, n)
+	for i := range accounts {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("generating key: %v", err)
+		}
+		accounts[i] = account{key: key, id: database.PublicKeyToAccountID(key.PublicKey)}
+	}
+
+	return accounts
+}
+
+// newTx constructs a signed transaction from the account with the specified
+// nonce, tip and timestamp.
+func newTx(t *testing.T, from account, nonce uint64, tip uint64, timeStamp uint64) database.BlockTx {
+	to := database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+	tx, err := database.NewTx(1, nonce, from.id, to, 100, tip, nil)
+	if err != nil {
+		t.Fatalf("constructing tx: %v", err)
+	}
+
+	signedTx, err := tx.Sign(from.key)
+	if err != nil {
+		t.Fatalf("signing tx: %v", err)
+	}
+
+	blockTx := database.NewBlockTx(signedTx, 15, 1)
+	blockTx.TimeStamp = timeStamp
+
+	return blockTx
+}
+
+// nonces returns the nonces held in the mempool for each account.
+func nonces(mp *mempool.Mempool, accounts []account) map[int][]uint64 {
+	m := make(map[int][]uint64)
+	for i, acct := range accounts {
+		for _, tx := range mp.AccountTxs(acct.id) {
+			m[i] = append(m[i], tx.Nonce)
+		}
+	}
+
+	return m
+}
+
+// equalNonces compares the nonces held for each account.
+func equalNonces(got map[int][]uint64, exp map[int][]uint64) bool {
+	if len(got) != len(exp) {
+		return false
+	}
+
+	for i, nonces := range exp {
+		if len(got[i]) != len(nonces) {
+			return false
+		}
+		for j := range nonces {
+			if got[i][j] != nonces[j] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// =============================================================================
+
+// txSpec describes a transaction for the account at the index.
+type txSpec struct {
+	account int
+	nonce   uint64
+	tip     uint64
+}
+
+func Test_Limits(t *testing.T) {
+	table := []struct {
+		name   string
+		limits mempool.Limits
+		held   []txSpec
+		tx     txSpec
+		full   bool
+		exp    map[int][]uint64
+	}{
+		{
+			name:   "under limits",
+			limits: mempool.Limits{MaxTxs: 3, MaxAccountTxs: 2},
+			held:   []txSpec{{0, 1, 1}, {1, 1, 1}},
+			tx:     txSpec{2, 1, 1},
+			exp:    map[int][]uint64{0: {1}, 1: {1}, 2: {1}},
+		},
+		{
+			name:   "pool limit evicts lowest tip",
+			limits: mempool.Limits{MaxTxs: 2},
+			held:   []txSpec{{0, 1, 1}, {1, 1, 5}},
+			tx:     txSpec{2, 1, 3},
+			exp:    map[int][]uint64{1: {1}, 2: {1}},
+		},
+		{
+			name:   "pool limit rejects lower tip",
+			limits: mempool.Limits{MaxTxs: 2},
+			held:   []txSpec{{0, 1, 5}, {1, 1, 5}},
+			tx:     txSpec{2, 1, 3},
+			full:   true,
+			exp:    map[int][]uint64{0: {1}, 1: {1}},
+		},
+		{
+			name:   "pool limit only evicts highest nonce",
+			limits: mempool.Limits{MaxTxs: 3},
+			held:   []txSpec{{0, 1, 1}, {0, 2, 10}, {1, 1, 5}},
+			tx:     txSpec{2, 1, 6},
+			exp:    map[int][]uint64{0: {1, 2}, 2: {1}},
+		},
+		{
+			name:   "pool limit doesn't evict earlier nonce of sender",
+			limits: mempool.Limits{MaxTxs: 2},
+			held:   []txSpec{{0, 1, 1}, {1, 1, 5}},
+			tx:     txSpec{0, 2, 3},
+			full:   true,
+			exp:    map[int][]uint64{0: {1}, 1: {1}},
+		},
+		{
+			name:   "account limit evicts highest nonce",
+			limits: mempool.Limits{MaxAccountTxs: 2},
+			held:   []txSpec{{0, 1, 5}, {0, 3, 5}},
+			tx:     txSpec{0, 2, 1},
+			exp:    map[int][]uint64{0: {1, 2}},
+		},
+		{
+			name:   "account limit rejects later nonce",
+			limits: mempool.Limits{MaxAccountTxs: 2},
+			held:   []txSpec{{0, 1, 1}, {0, 2, 1}},
+			tx:     txSpec{0, 3, 10},
+			full:   true,
+			exp:    map[int][]uint64{0: {1, 2}},
+		},
+		{
+			name:   "replacement needs no room",
+			limits: mempool.Limits{MaxTxs: 2, MaxAccountTxs: 2},
+			held:   []txSpec{{0, 1, 10}, {0, 2, 10}},
+			tx:     txSpec{0, 2, 20},
+			exp:    map[int][]uint64{0: {1, 2}},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := newAccounts(t, 3)
+
+			mp, err := mempool.NewWithConfig(mempool.Config{Strategy: selector.StrategyTip, Limits: tt.limits})
+			if err != nil {
+				t.Fatalf("constructing mempool: %v", err)
+			}
+
+			for i, spec := range tt.held {
+				if err := mp.Upsert(newTx(t, accounts[spec.account], spec.nonce, spec.tip, uint64(i+1))); err != nil {
+					t.Fatalf("upserting held tx %d: %v", i, err)
+				}
+			}
+
+			err = mp.Upsert(newTx(t, accounts[tt.tx.account], tt.tx.nonce, tt.tx.tip, uint64(len(tt.held)+1)))
+			if full := errors.Is(err, mempool.ErrPoolFull); full != tt.full {
+				t.Fatalf("expected pool full %t, got %v", tt.full, err)
+			}
+			if !tt.full && err != nil {
+				t.Fatalf("upserting tx: %v", err)
+			}
+
+			if got := nonces(mp, accounts); !equalNonces(got, tt.exp) {
+				t.Errorf("expected nonces %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func Test_Lanes(t *testing.T) {
+	table := []struct {
+		name     string
+		nonce    uint64   // Account nonce in the database.
+		upserts  []uint64 // Nonces upserted in order.
+		mined    uint64   // Account nonce after a block, 0 for no block.
+		rejected int
+		pending  int
+		queued   int
+	}{
+		{name: "in order is pending", nonce: 0, upserts: []uint64{1, 2}, pending: 2},
+		{name: "gap is queued", nonce: 0, upserts: []uint64{2, 3}, queued: 2},
+		{name: "filled gap is promoted", nonce: 0, upserts: []uint64{2, 3, 1}, pending: 3},
+		{name: "block promotes queued", nonce: 0, upserts: []uint64{2, 3}, mined: 1, pending: 2},
+		{name: "block drops used nonces", nonce: 0, upserts: []uint64{1, 2, 3}, mined: 2, pending: 1},
+		{name: "used nonce is rejected", nonce: 2, upserts: []uint64{2, 3}, rejected: 1, pending: 1},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := newAccounts(t, 1)
+			nonce := tt.nonce
+
+			mp, err := mempool.NewWithConfig(mempool.Config{
+				Strategy: selector.StrategyTip,
+				Nonce:    func(accountID database.AccountID) uint64 { return nonce },
+			})
+			if err != nil {
+				t.Fatalf("constructing mempool: %v", err)
+			}
+
+			var rejected int
+			for i, n := range tt.upserts {
+				if err := mp.Upsert(newTx(t, accounts[0], n, 1, uint64(i+1))); err != nil {
+					rejected++
+				}
+			}
+
+			if tt.mined > 0 {
+				nonce = tt.mined
+				mp.UpdateLanes()
+			}
+
+			if rejected != tt.rejected {
+				t.Errorf("expected %d rejected, got %d", tt.rejected, rejected)
+			}
+			if got := mp.PendingCount(); got != tt.pending {
+				t.Errorf("expected %d pending, got %d", tt.pending, got)
+			}
+			if got := len(mp.Queued()); got != tt.queued {
+				t.Errorf("expected %d queued, got %d", tt.queued, got)
+			}
+			if got := mp.Count(); got != tt.pending+tt.queued {
+				t.Errorf("expected %d held, got %d", tt.pending+tt.queued, got)
+			}
+		})
+	}
+}
+
+func Test_Expire(t *testing.T) {
+	table := []struct {
+		name    string
+		stamps  []uint64 // Timestamps for nonces 1, 2, 3...
+		before  uint64
+		expired int
+		pending int
+		queued  int
+	}{
+		{name: "nothing expired", stamps: []uint64{100, 200, 300}, before: 50, pending: 3},
+		{name: "expired first nonce queues the rest", stamps: []uint64{100, 200, 300}, before: 150, expired: 1, queued: 2},
+		{name: "expired last nonces", stamps: []uint64{300, 200, 100}, before: 250, expired: 2, pending: 1},
+		{name: "everything expired", stamps: []uint64{100, 200, 300}, before: 350, expired: 3},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := newAccounts(t, 1)
+
+			mp, err := mempool.NewWithConfig(mempool.Config{
+				Strategy: selector.StrategyTip,
+				Nonce:    func(accountID database.AccountID) uint64 { return 0 },
+			})
+			if err != nil {
+				t.Fatalf("constructing mempool: %v", err)
+			}
+
+			for i, stamp := range tt.stamps {
+				if err := mp.Upsert(newTx(t, accounts[0], uint64(i+1), 1, stamp)); err != nil {
+					t.Fatalf("upserting tx %d: %v", i, err)
+				}
+			}
+
+			expired := mp.Expire(tt.before)
+			if len(expired) != tt.expired {
+				t.Fatalf("expected %d expired, got %d", tt.expired, len(expired))
+			}
+
+			for _, tx := range expired {
+				if tx.TimeStamp >= tt.before {
+					t.Errorf("tx %s expired with timestamp %d", tx, tx.TimeStamp)
+				}
+				if !mp.RecentlyRemoved(tx) {
+					t.Errorf("expired tx %s is not remembered", tx)
+				}
+			}
+
+			if got := mp.PendingCount(); got != tt.pending {
+				t.Errorf("expected %d pending, got %d", tt.pending, got)
+			}
+			if got := len(mp.Queued()); got != tt.queued {
+				t.Errorf("expected %d queued, got %d", tt.queued, got)
+			}
+		})
+	}
+}
+
+func Test_Journal(t *testing.T) {
+	table := []struct {
+		name     string
+		upserts  []txSpec
+		deletes  []int // Indexes of the upserted transactions to delete.
+		truncate bool
+		reset    []txSpec // Remove the journal's directory, like a database reset, then upsert these.
+		torn     bool     // Leave a partial line at the end of the journal.
+		exp      map[int][]uint64
+	}{
+		{
+			name:    "reload held",
+			upserts: []txSpec{{0, 1, 1}, {0, 2, 1}, {1, 1, 1}},
+			exp:     map[int][]uint64{0: {1, 2}, 1: {1}},
+		},
+		{
+			name:    "deleted not reloaded",
+			upserts: []txSpec{{0, 1, 1}, {0, 2, 1}, {1, 1, 1}},
+			deletes: []int{0, 2},
+			exp:     map[int][]uint64{0: {2}},
+		},
+		{
+			name:    "torn line dropped",
+			upserts: []txSpec{{0, 1, 1}, {1, 1, 1}},
+			torn:    true,
+			exp:     map[int][]uint64{0: {1}, 1: {1}},
+		},
+		{
+			name:     "truncate clears",
+			upserts:  []txSpec{{0, 1, 1}, {1, 1, 1}},
+			truncate: true,
+			exp:      map[int][]uint64{},
+		},
+		{
+			name:    "reset keeps held",
+			upserts: []txSpec{{0, 1, 1}, {1, 1, 1}},
+			reset:   []txSpec{{0, 2, 1}},
+			exp:     map[int][]uint64{0: {1, 2}, 1: {1}},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := newAccounts(t, 2)
+			path := filepath.Join(t.TempDir(), "db", "mempool.jsonl")
+
+			journal, err := mempool.OpenJournal(path)
+			if err != nil {
+				t.Fatalf("opening journal: %v", err)
+			}
+
+			mp, err := mempool.NewWithConfig(mempool.Config{Strategy: selector.StrategyTip, Journal: journal})
+			if err != nil {
+				t.Fatalf("constructing mempool: %v", err)
+			}
+
+			trans := make([]database.BlockTx, len(tt.upserts))
+			for i, spec := range tt.upserts {
+				trans[i] = newTx(t, accounts[spec.account], spec.nonce, spec.tip, uint64(i+1))
+				if err := mp.Upsert(trans[i]); err != nil {
+					t.Fatalf("upserting tx %d: %v", i, err)
+				}
+			}
+
+			for _, i := range tt.deletes {
+				if err := mp.Delete(trans[i]); err != nil {
+					t.Fatalf("deleting tx %d: %v", i, err)
+				}
+			}
+
+			if tt.truncate {
+				mp.Truncate()
+			}
+
+			if tt.reset != nil {
+				if err := os.RemoveAll(filepath.Dir(path)); err != nil {
+					t.Fatalf("removing journal directory: %v", err)
+				}
+				if err := mp.CompactJournal(); err != nil {
+					t.Fatalf("compacting journal: %v", err)
+				}
+				for i, spec := range tt.reset {
+					tx := newTx(t, accounts[spec.account], spec.nonce, spec.tip, uint64(len(trans)+i+1))
+					if err := mp.Upsert(tx); err != nil {
+						t.Fatalf("upserting tx after reset %d: %v", i, err)
+					}
+				}
+			}
+
+			if err := mp.Close(); err != nil {
+				t.Fatalf("closing mempool: %v", err)
+			}
+
+			if tt.torn {
+				f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+				if err != nil {
+					t.Fatalf("opening journal file: %v", err)
+				}
+				if _, err := f.WriteString(`{"tx":{"nonce":`); err != nil {
+					t.Fatalf("writing torn line: %v", err)
+				}
+				f.Close()
+			}
+
+			journal, err = mempool.OpenJournal(path)
+			if err != nil {
+				t.Fatalf("reopening journal: %v", err)
+			}
+			defer journal.Close()
+
+			loaded, err := journal.Load()
+			if err != nil {
+				t.Fatalf("loading journal: %v", err)
+			}
+
+			got := make(map[int][]uint64)
+			for _, tx := range loaded {
+				for i, acct := range accounts {
+					if tx.FromID == acct.id {
+						got[i] = append(got[i], tx.Nonce)
+					}
+				}
+			}
+
+			if !equalNonces(got, tt.exp) {
+				t.Errorf("expected nonces %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
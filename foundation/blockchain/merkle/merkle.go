@@ -183,6 +183,37 @@ func (t *Tree[T]) Proof(data T) ([][]byte, []int64, error) {
 	return nil, nil, errors.New("unable to find data in tree")
 }
 
+// VerifyProof checks the hash of a value is in a tree with the specified
+// merkle root, using the proof and order returned by Proof. The tree isn't
+// needed, so a client can check a proof provided by someone else. The hashes
+// are combined using sha256, the default hash strategy of a tree.
+func VerifyProof(hash []byte, proof [][]byte, order []int64, merkleRoot []byte) error {
+	if len(proof) != len(order) {
+		return errors.New("proof and order are not the same length")
+	}
+
+	for i := range proof {
+		var data []byte
+		switch order[i] {
+		case 0:
+			data = append(append(data, proof[i]...), hash...) // left leaf, concat first.
+		case 1:
+			data = append(append(data, hash...), proof[i]...) // right leaf, concat second.
+		default:
+			return fmt.Errorf("invalid order %d at position %d", order[i], i)
+		}
+
+		sum := sha256.Sum256(data)
+		hash = sum[:]
+	}
+
+	if !bytes.Equal(hash, merkleRoot) {
+		return errors.New("merkle root is not equivalent to the merkle root calculated from the proof")
+	}
+
+	return nil
+}
+
 // Verify validates the hashes at each level of the tree and returns true
 // if the resulting hash at the root of the tree matches the resulting root hash.
 func (t *Tree[T]) Verify() error {
@@ -204,6 +204,51 @@ func Test_MerklePath(t *testing.T) {
 	}
 }
 
+func Test_VerifyProof(t *testing.T) {
+	for i := 0; i < len(table); i++ {
+		tree, err := merkle.NewTree(table[i].data, merkle.WithHashStrategy[Data](table[i].hashStrategy))
+		if err != nil {
+			t.Errorf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+		}
+		for j := 0; j < len(table[i].data); j++ {
+			merkleProof, order, err := tree.Proof(table[i].data[j])
+			if err != nil {
+				t.Errorf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+
+			hash, err := table[i].data[j].Hash()
+			if err != nil {
+				t.Errorf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+
+			if err := merkle.VerifyProof(hash, merkleProof, order, tree.MerkleRoot); err != nil {
+				t.Errorf("[case:%d] error: expected valid proof for data %d: %v", table[i].testCaseID, j, err)
+			}
+
+			notInContents, err := table[i].notInContents.Hash()
+			if err != nil {
+				t.Errorf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+			if err := merkle.VerifyProof(notInContents, merkleProof, order, tree.MerkleRoot); err == nil {
+				t.Errorf("[case:%d] error: expected invalid proof for content not in the tree", table[i].testCaseID)
+			}
+
+			if err := merkle.VerifyProof(hash, merkleProof, order, []byte{1}); err == nil {
+				t.Errorf("[case:%d] error: expected invalid proof for the wrong root", table[i].testCaseID)
+			}
+
+			if err := merkle.VerifyProof(hash, merkleProof, order[1:], tree.MerkleRoot); err == nil {
+				t.Errorf("[case:%d] error: expected invalid proof for a short order", table[i].testCaseID)
+			}
+
+			badOrder := append([]int64{2}, order[1:]...)
+			if err := merkle.VerifyProof(hash, merkleProof, badOrder, tree.MerkleRoot); err == nil {
+				t.Errorf("[case:%d] error: expected invalid proof for an invalid order", table[i].testCaseID)
+			}
+		}
+	}
+}
+
 // =============================================================================
 
 func calHash(hash []byte, hashStrategy func() hash.Hash) ([]byte, error) {
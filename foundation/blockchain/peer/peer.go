@@ -0,0 +1,94 @@
+// Package peer maintains the peer related information such as the set
+// of known peers and their status.
+package peer
+
+import (
+	"math/big"
+	"sync"
+)
+
+// Peer represents information about a Node in the network.
+type Peer struct {
+	Host string `json:"host"`
+}
+
+// New constructs a new info value.
+func New(host string) Peer {
+	return Peer{
+		Host: host,
+	}
+}
+
+// Match validates if the specified host matches this node.
+func (p Peer) Match(host string) bool {
+	return p.Host == host
+}
+
+// String implements the Stringer interface for logging.
+func (p Peer) String() string {
+	return p.Host
+}
+
+// =============================================================================
+
+// PeerStatus represents information about the status
+// of any given peer. TotalWork is the work of all the blocks in the
+// peer's chain and is what decides which chain to follow.
+type PeerStatus struct {
+	LatestBlockHash   string   `json:"latest_block_hash"`
+	LatestBlockNumber uint64   `json:"latest_block_number"`
+	TotalWork         *big.Int `json:"total_work"`
+	KnownPeers        []Peer   `json:"known_peers"`
+}
+
+// =============================================================================
+
+// PeerSet represents the data representation to maintain a set of known peers.
+type PeerSet struct {
+	mu  sync.RWMutex
+	set map[Peer]struct{}
+}
+
+// NewPeerSet constructs a new info set to manage node peer information.
+func NewPeerSet() *PeerSet {
+	return &PeerSet{
+		set: make(map[Peer]struct{}),
+	}
+}
+
+// Add adds a new node to the set. It returns true if the peer was not
+// already part of the set.
+func (ps *PeerSet) Add(peer Peer) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, exists := ps.set[peer]; exists {
+		return false
+	}
+
+	ps.set[peer] = struct{}{}
+	return true
+}
+
+// Remove removes a node from the set.
+func (ps *PeerSet) Remove(peer Peer) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.set, peer)
+}
+
+// Copy returns a list of the known peers excluding the specified host.
+func (ps *PeerSet) Copy(host string) []Peer {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	peers := make([]Peer, 0, len(ps.set))
+	for peer := range ps.set {
+		if !peer.Match(host) {
+			peers = append(peers, peer)
+		}
+	}
+
+	return peers
+}
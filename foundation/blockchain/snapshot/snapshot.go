@@ -0,0 +1,195 @@
+// Package snapshot implements the ability to save and load snapshots of the
+// accounts to disk, so a node doesn't have to replay the entire chain when it
+// starts.
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// keepSnapshots is the number of snapshots kept on disk. Older snapshots are
+// kept so there is one to fall back on if the latest can't be used.
+const keepSnapshots = 3
+
+// snapshotExt is the file extension used for the snapshot files.
+const snapshotExt = ".json"
+
+// tempPattern is used to name the files snapshots are written to before
+// they are renamed into place.
+const tempPattern = ".tmp-*"
+
+// Snapshots represents the serialization implementation for reading and
+// storing account snapshots on disk. Each snapshot is a file named by its
+// block number. This implements the database.Snapshotter interface.
+type Snapshots struct {
+	dirPath  string
+	interval uint64
+}
+
+// New constructs a Snapshots value for use. A snapshot is taken every time
+// the specified interval of blocks is written.
+func New(dirPath string, interval uint64) (*Snapshots, error) {
+	if interval == 0 {
+		return nil, errors.New("snapshot interval must be greater than 0")
+	}
+
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return nil, err
+	}
+
+	names, err := filepath.Glob(filepath.Join(dirPath, tempPattern))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Snapshots{dirPath: dirPath, interval: interval}, nil
+}
+
+// Interval returns the number of blocks between snapshots.
+func (s *Snapshots) Interval() uint64 {
+	return s.interval
+}
+
+// Write saves the snapshot to disk and removes the snapshots that are no
+// longer kept.
+func (s *Snapshots) Write(snapshot database.Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeFile(s.getPath(snapshot.BlockNumber), data); err != nil {
+		return err
+	}
+
+	nums, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for i := keepSnapshots; i < len(nums); i++ {
+		if err := os.Remove(s.getPath(nums[i])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List returns the block numbers of the snapshots on disk, latest first.
+func (s *Snapshots) List() ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(s.dirPath, "*"+snapshotExt))
+	if err != nil {
+		return nil, err
+	}
+
+	nums := make([]uint64, 0, len(names))
+	for _, name := range names {
+		num, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), snapshotExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		nums = append(nums, num)
+	}
+
+	sort.Slice(nums, func(i, j int) bool { return nums[i] > nums[j] })
+
+	return nums, nil
+}
+
+// Read loads the snapshot for the specified block number from disk.
+func (s *Snapshots) Read(num uint64) (database.Snapshot, error) {
+	data, err := os.ReadFile(s.getPath(num))
+	if err != nil {
+		return database.Snapshot{}, err
+	}
+
+	var snapshot database.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return database.Snapshot{}, err
+	}
+
+	if snapshot.BlockNumber != num {
+		return database.Snapshot{}, fmt.Errorf("snapshot is for block %d", snapshot.BlockNumber)
+	}
+
+	return snapshot, nil
+}
+
+// Truncate removes the snapshots for the blocks after the specified block
+// number from disk.
+func (s *Snapshots) Truncate(num uint64) error {
+	nums, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for _, n := range nums {
+		if n <= num {
+			break
+		}
+		if err := os.Remove(s.getPath(n)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reset removes all the snapshots from disk.
+func (s *Snapshots) Reset() error {
+	if err := os.RemoveAll(s.dirPath); err != nil {
+		return err
+	}
+
+	return os.MkdirAll(s.dirPath, 0755)
+}
+
+// =============================================================================
+
+// writeFile atomically replaces the named file with the data by writing to a
+// temporary file, syncing it to disk and renaming it into place.
+func (s *Snapshots) writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(s.dirPath, tempPattern)
+	if err != nil {
+		return err
+	}
+
+	if err := func() error {
+		defer f.Close()
+
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		return f.Sync()
+	}(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// getPath forms the path to the specified snapshot.
+func (s *Snapshots) getPath(num uint64) string {
+	return filepath.Join(s.dirPath, fmt.Sprintf("%d%s", num, snapshotExt))
+}
@@ -0,0 +1,153 @@
+package snapshot_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/snapshot"
+)
+
+// newSnapshot constructs a snapshot for the specified block number.
+func newSnapshot(num uint64) database.Snapshot {
+	return database.Snapshot{
+		BlockNumber: num,
+		StateRoot:   "0x01",
+		Accounts:    []database.Account{{AccountID: "0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76", Balance: num}},
+	}
+}
+
+// equal reports whether the block numbers match in order.
+func equal(got []uint64, exp []uint64) bool {
+	if len(got) != len(exp) {
+		return false
+	}
+	for i := range got {
+		if got[i] != exp[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// =============================================================================
+
+func Test_New(t *testing.T) {
+	if _, err := snapshot.New(t.TempDir(), 0); err == nil {
+		t.Errorf("expected an interval of 0 to be rejected")
+	}
+
+	// Snapshots that were never renamed into place are removed.
+	dirPath := t.TempDir()
+	temp := filepath.Join(dirPath, ".tmp-123")
+	if err := os.WriteFile(temp, []byte("{"), 0600); err != nil {
+		t.Fatalf("writing temp file: %v", err)
+	}
+
+	snapshots, err := snapshot.New(dirPath, 2)
+	if err != nil {
+		t.Fatalf("constructing snapshots: %v", err)
+	}
+	if _, err := os.Stat(temp); !os.IsNotExist(err) {
+		t.Errorf("expected the temp file to be removed, got %v", err)
+	}
+	if got := snapshots.Interval(); got != 2 {
+		t.Errorf("expected interval 2, got %d", got)
+	}
+}
+
+func Test_WriteRead(t *testing.T) {
+	snapshots, err := snapshot.New(t.TempDir(), 2)
+	if err != nil {
+		t.Fatalf("constructing snapshots: %v", err)
+	}
+
+	for _, num := range []uint64{2, 4, 6, 8, 10} {
+		if err := snapshots.Write(newSnapshot(num)); err != nil {
+			t.Fatalf("writing snapshot %d: %v", num, err)
+		}
+	}
+
+	// Only the latest snapshots are kept.
+	nums, err := snapshots.List()
+	if err != nil {
+		t.Fatalf("listing snapshots: %v", err)
+	}
+	if exp := []uint64{10, 8, 6}; !equal(nums, exp) {
+		t.Fatalf("expected snapshots %v, got %v", exp, nums)
+	}
+
+	snap, err := snapshots.Read(8)
+	if err != nil {
+		t.Fatalf("reading snapshot: %v", err)
+	}
+	if snap.BlockNumber != 8 || len(snap.Accounts) != 1 || snap.Accounts[0].Balance != 8 {
+		t.Errorf("expected snapshot 8, got %+v", snap)
+	}
+
+	if _, err := snapshots.Read(4); err == nil {
+		t.Errorf("expected removed snapshot 4 not to be read")
+	}
+}
+
+func Test_ReadMismatch(t *testing.T) {
+	dirPath := t.TempDir()
+
+	snapshots, err := snapshot.New(dirPath, 2)
+	if err != nil {
+		t.Fatalf("constructing snapshots: %v", err)
+	}
+	if err := snapshots.Write(newSnapshot(4)); err != nil {
+		t.Fatalf("writing snapshot: %v", err)
+	}
+
+	// A snapshot stored under the wrong block number can't be used.
+	if err := os.Rename(filepath.Join(dirPath, "4.json"), filepath.Join(dirPath, "6.json")); err != nil {
+		t.Fatalf("renaming snapshot: %v", err)
+	}
+	if _, err := snapshots.Read(6); err == nil {
+		t.Errorf("expected snapshot for block 4 to be rejected as block 6")
+	}
+}
+
+func Test_Truncate(t *testing.T) {
+	table := []struct {
+		name string
+		num  uint64
+		exp  []uint64
+	}{
+		{name: "none removed", num: 6, exp: []uint64{6, 4, 2}},
+		{name: "between snapshots", num: 5, exp: []uint64{4, 2}},
+		{name: "on a snapshot", num: 4, exp: []uint64{4, 2}},
+		{name: "all removed", num: 0, exp: []uint64{}},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshots, err := snapshot.New(t.TempDir(), 2)
+			if err != nil {
+				t.Fatalf("constructing snapshots: %v", err)
+			}
+
+			for _, num := range []uint64{2, 4, 6} {
+				if err := snapshots.Write(newSnapshot(num)); err != nil {
+					t.Fatalf("writing snapshot %d: %v", num, err)
+				}
+			}
+
+			if err := snapshots.Truncate(tt.num); err != nil {
+				t.Fatalf("truncating: %v", err)
+			}
+
+			nums, err := snapshots.List()
+			if err != nil {
+				t.Fatalf("listing snapshots: %v", err)
+			}
+			if !equal(nums, tt.exp) {
+				t.Errorf("expected snapshots %v, got %v", tt.exp, nums)
+			}
+		})
+	}
+}
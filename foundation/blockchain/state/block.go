@@ -6,20 +6,38 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+	"math/big"
 )
 
 // =============================================================================
 
 // The set of different consensus protocols that can be used.
 const (
-	ConsensusPOW = "POW"
-	ConsensusPOA = "POA"
+	ConsensusPOW = database.ConsensusPOW
+	ConsensusPOA = database.ConsensusPOA
 )
 
 // ErrNoTransactions is returned when a block is requested to be created
 // and there are not enough transactions.
 var ErrNoTransactions = errors.New("no transactions in mempool")
 
+// ErrNotScheduled is returned under POA consensus when a block is requested
+// to be created by a node that isn't the scheduled authority.
+var ErrNotScheduled = errors.New("node is not the scheduled authority")
+
+// ErrResyncInProgress is returned when a block is proposed while the node
+// is re-syncing its blockchain with a peer.
+var ErrResyncInProgress = errors.New("blockchain resync in progress")
+
+// errNoCommonBlock is returned when a peer shares no block with this node
+// within the blocks that can be rolled back.
+var errNoCommonBlock = errors.New("no common block within rollback depth")
+
+// resyncBatchSize is the number of blocks requested from a peer at a time
+// while re-syncing the blockchain.
+const resyncBatchSize = 100
+
 // =============================================================================
 
 // MineNewBlock attempts to create a new block with a proper hash that can become
@@ -30,29 +48,62 @@ func (s *State) MineNewBlock(ctx context.Context) (database.Block, error) {
 	s.evHandler("state: MineNewBlock: MINING: check mempool count")
 
 	// Are there enough transactions in the pool.
-	if s.mempool.Count() == 0 {
+	if s.mempool.PendingCount() == 0 {
 		return database.Block{}, ErrNoTransactions
 	}
 
 	// Pick the best transactions from the mempool.
 	trans := s.mempool.PickBest(s.genesis.TransPerBlock)
 
-	difficulty := s.genesis.Difficulty
-
-	// Attempt to create a new block by solving the POW puzzle. This can be cancelled.
-	block, err := database.POW(ctx, database.POWArgs{
-		BeneficiaryID: s.beneficiaryID,
-		Difficulty:    difficulty,
-		MiningReward:  s.genesis.MiningReward,
-		PrevBlock:     s.db.LatestBlock(),
-		StateRoot:     s.db.HashState(),
-		Trans:         trans,
-		EvHandler:     s.evHandler,
-	})
+	var block database.Block
+	var err error
+
+	switch s.consensus {
+	case ConsensusPOA:
+
+		// Only the authority scheduled for the next block number can produce it.
+		if !s.IsScheduledSigner() {
+			return database.Block{}, ErrNotScheduled
+		}
+
+		// Produce the new block, there is no puzzle to solve.
+		block, err = database.POA(database.POAArgs{
+			BeneficiaryID: s.beneficiaryID,
+			MiningReward:  s.genesis.MiningReward,
+			PrevBlock:     s.db.LatestBlock(),
+			StateRoot:     s.db.HashState(),
+			Trans:         trans,
+		})
+
+	default:
+		// Calculate the difficulty the next block has to be mined at.
+		var difficulty uint64
+		if difficulty, err = s.db.NextDifficulty(); err != nil {
+			return database.Block{}, err
+		}
+
+		// Attempt to create a new block by solving the POW puzzle. This can be cancelled.
+		block, err = database.POW(ctx, database.POWArgs{
+			BeneficiaryID: s.beneficiaryID,
+			Difficulty:    difficulty,
+			MiningReward:  s.genesis.MiningReward,
+			PrevBlock:     s.db.LatestBlock(),
+			StateRoot:     s.db.HashState(),
+			Trans:         trans,
+			Rules:         s.db.Rules(),
+			Workers:       s.miningWorkers,
+			EvHandler:     s.evHandler,
+		})
+	}
 	if err != nil {
 		return database.Block{}, err
 	}
 
+	// Sign the block header so peers can verify this node produced it.
+	if err := block.Sign(s.beneficiaryKey); err != nil {
+		return database.Block{}, err
+	}
+
 	// Just check one more time we were not cancelled.
 	if ctx.Err() != nil {
 		return database.Block{}, ctx.Err()
@@ -68,6 +119,388 @@ func (s *State) MineNewBlock(ctx context.Context) (database.Block, error) {
 	return block, nil
 }
 
+// ProcessProposedBlock takes a block received from a peer, validates it and
+// if that passes, adds the block to the local blockchain.
+func (s *State) ProcessProposedBlock(block database.Block) error {
+	s.evHandler("state: ProcessProposedBlock: started: prevBlk[%s]: newBlk[%s]: numTrans[%d]", block.Header.PrevBlockHash, block.Hash(), len(block.MerkleTree.Values()))
+	defer s.evHandler("state: ProcessProposedBlock: completed: newBlk[%s]", block.Hash())
+
+	// Blocks can't be accepted while the chain is being rebuilt.
+	if s.IsResyncing() {
+		return ErrResyncInProgress
+	}
+
+	// Validate the block and then update the blockchain database. If this
+	// node is on the wrong side of a fork, the chain needs to be re-synced.
+	if err := s.validateUpdateDatabase(block); err != nil {
+		if errors.Is(err, database.ErrChainForked) {
+			s.startResync()
+		}
+		return err
+	}
+
+	// If the runPowOperation function is being executed it needs to stop
+	// immediately since this block replaces the one being mined.
+	s.Worker.SignalCancelMining()
+
+	return nil
+}
+
+// IsScheduledSigner reports whether this node's beneficiary is the authority
+// scheduled to produce the next block under POA consensus.
+func (s *State) IsScheduledSigner() bool {
+	signer, err := s.db.Rules().ScheduledSigner(s.db.LatestBlock().Header.Number + 1)
+	if err != nil {
+		return false
+	}
+
+	return signer == s.beneficiaryID
+}
+
+// Resync brings the chain in line with the peer holding the chain with the
+// most work. This is used to correct an identified fork. The peer's branch
+// after the last block shared with the peer is downloaded and checked before
+// any of our blocks are touched. Then our blocks after the shared block are
+// rolled back and the peer's branch is applied. If the branch can't be
+// applied, our blocks are put back. Only when the peer shares no block within
+// the blocks that can be rolled back is the chain reset both on disk and in
+// memory and the peer's blocks replayed from genesis. No mining is allowed
+// to take place while this process is running. New transactions can still be
+// placed into the mempool.
+func (s *State) Resync() error {
+	s.evHandler("state: Resync: started")
+	defer s.evHandler("state: Resync: completed")
+
+	// Don't allow mining or proposed blocks while the chain is being rebuilt
+	// and stop any mining operation that is currently running.
+	s.mu.Lock()
+	s.resyncing = true
+	s.allowMining = false
+	s.mu.Unlock()
+
+	s.Worker.SignalCancelMining()
+
+	defer func() {
+		s.mu.Lock()
+		s.resyncing = false
+		s.mu.Unlock()
+
+		s.TurnMiningOn()
+		s.Worker.SignalStartMining()
+	}()
+
+	// Find the peer holding the chain with the most work. There is nothing
+	// to do if no peer holds more work than this node.
+	pr, status, err := s.heaviestPeer()
+	if err != nil {
+		return err
+	}
+
+	s.evHandler("state: Resync: heaviest chain: peer[%s]: latest-blknum[%d]: total-work[%s]", pr, status.LatestBlockNumber, status.TotalWork)
+
+	// Find the latest block shared with the peer. Any error other than the
+	// peer sharing no block leaves the chain as it is.
+	ancestor, err := s.commonAncestor(pr)
+	reset := errors.Is(err, errNoCommonBlock)
+	if err != nil && !reset {
+		return err
+	}
+
+	// Check the peer's branch before any of our blocks are removed.
+	hashes, err := s.validateBranch(pr, ancestor, status.LatestBlockNumber)
+	if err != nil {
+		return fmt.Errorf("checking peer branch: %w", err)
+	}
+
+	var orphaned []database.Block
+	switch {
+	case reset:
+		s.evHandler("state: Resync: %s: reset to genesis", errNoCommonBlock)
+
+		if err := s.db.Reset(); err != nil {
+			return err
+		}
+
+		// The mempool journal can be kept with the blockchain, so it's
+		// written again in case the reset removed it.
+		if err := s.mempool.CompactJournal(); err != nil {
+			s.evHandler("state: Resync: journal: ERROR: %s", err)
+		}
+
+	default:
+		s.evHandler("state: Resync: reorganize: common-blknum[%d]", ancestor)
+
+		if orphaned, err = s.rollback(ancestor); err != nil {
+			return err
+		}
+	}
+
+	// Apply the peer's branch, putting our blocks back if that fails.
+	if err := s.applyBranch(pr, ancestor, hashes); err != nil {
+		if !reset {
+			s.restore(ancestor, orphaned)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// startResync launches a resync on its own goroutine unless one is
+// already running.
+func (s *State) startResync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.resyncing {
+		return
+	}
+	s.resyncing = true
+
+	s.resyncWG.Add(1)
+	go func() {
+		defer s.resyncWG.Done()
+
+		s.evHandler("state: startResync: chain fork detected: resync")
+		if err := s.Resync(); err != nil {
+			s.evHandler("state: startResync: ERROR: %s", err)
+		}
+	}()
+}
+
+// commonAncestor finds the latest block this node shares with the peer
+// within the blocks that can be rolled back. If there is none,
+// errNoCommonBlock is returned.
+func (s *State) commonAncestor(pr peer.Peer) (uint64, error) {
+	latestNumber := s.db.LatestBlock().Header.Number
+	lowest := latestNumber - s.db.RollbackDepth()
+
+	if latestNumber > 0 {
+		blocks, err := s.NetRequestPeerBlocks(pr, lowest+1, latestNumber)
+		if err != nil {
+			return 0, err
+		}
+
+		// Only the headers are compared, since the block hash is the hash
+		// of the header.
+		for i := len(blocks) - 1; i >= 0; i-- {
+			header, err := s.db.GetHeader(blocks[i].Header.Number)
+			if err != nil {
+				return 0, err
+			}
+
+			if (database.Block{Header: header}).Hash() == blocks[i].Hash() {
+				return header.Number, nil
+			}
+		}
+	}
+
+	// Every block can be rolled back, so genesis is shared.
+	if lowest == 0 {
+		return 0, nil
+	}
+
+	return 0, errNoCommonBlock
+}
+
+// validateBranch downloads the peer's blocks after the ancestor and checks
+// they link up and follow the consensus rules. The accounts after the
+// ancestor aren't known until the blocks are applied, so the state roots
+// are checked then. The branch must hold more work than our blocks after
+// the ancestor. The hashes of the checked blocks are returned so the same
+// blocks are applied. Only the headers are kept, since the branch can be
+// too large to hold in memory.
+func (s *State) validateBranch(pr peer.Peer, ancestor uint64, latest uint64) ([]string, error) {
+	rules := s.db.Rules()
+
+	var parent database.Block
+	if ancestor > 0 {
+		header, err := s.db.GetHeader(ancestor)
+		if err != nil {
+			return nil, err
+		}
+		parent = database.Block{Header: header}
+	}
+
+	// The difficulty of a block depends on the headers before it, which
+	// come from the branch after the ancestor.
+	var headers []database.BlockHeader
+	getHeader := func(num uint64) (database.BlockHeader, error) {
+		if num <= ancestor {
+			return s.db.GetHeader(num)
+		}
+		if num-ancestor > uint64(len(headers)) {
+			return database.BlockHeader{}, fmt.Errorf("block %d is not in the branch", num)
+		}
+		return headers[num-ancestor-1], nil
+	}
+
+	var hashes []string
+	branchWork := big.NewInt(0)
+
+	for from := ancestor + 1; from <= latest; from += resyncBatchSize {
+		to := from + resyncBatchSize - 1
+		if to > latest {
+			to = latest
+		}
+
+		blocks, err := s.NetRequestPeerBlocks(pr, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, block := range blocks {
+			difficulty, err := rules.NextDifficulty(parent.Header, getHeader)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := block.ValidateBlock(parent, block.Header.StateRoot, difficulty, rules, s.evHandler); err != nil {
+				return nil, fmt.Errorf("block %d: %w", block.Header.Number, err)
+			}
+
+			headers = append(headers, block.Header)
+			hashes = append(hashes, block.Hash())
+			branchWork.Add(branchWork, rules.Work(block.Header.Number, block.Header.Difficulty))
+
+			parent = database.Block{Header: block.Header}
+		}
+	}
+
+	ourWork := big.NewInt(0)
+	for num := ancestor + 1; num <= s.db.LatestBlock().Header.Number; num++ {
+		header, err := s.db.GetHeader(num)
+		if err != nil {
+			return nil, err
+		}
+		ourWork.Add(ourWork, rules.Work(header.Number, header.Difficulty))
+	}
+
+	if branchWork.Cmp(ourWork) <= 0 {
+		return nil, fmt.Errorf("branch holds %s work after block %d, ours holds %s", branchWork, ancestor, ourWork)
+	}
+
+	return hashes, nil
+}
+
+// applyBranch downloads the checked branch after the ancestor again and
+// applies its blocks to the database. Blocks that don't match the checked
+// branch are rejected.
+func (s *State) applyBranch(pr peer.Peer, ancestor uint64, hashes []string) error {
+	latest := ancestor + uint64(len(hashes))
+
+	for from := ancestor + 1; from <= latest; from += resyncBatchSize {
+		to := from + resyncBatchSize - 1
+		if to > latest {
+			to = latest
+		}
+
+		blocks, err := s.NetRequestPeerBlocks(pr, from, to)
+		if err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			num := block.Header.Number
+			if num <= ancestor || num > latest || block.Hash() != hashes[num-ancestor-1] {
+				return fmt.Errorf("block %d does not match the checked branch", num)
+			}
+
+			if err := s.validateUpdateDatabase(block); err != nil {
+				return fmt.Errorf("replaying block %d: %w", num, err)
+			}
+		}
+	}
+
+	if num := s.db.LatestBlock().Header.Number; num != latest {
+		return fmt.Errorf("branch ended at block %d, exp %d", num, latest)
+	}
+
+	return nil
+}
+
+// restore puts our blocks back after the peer's branch failed to apply. The
+// blocks are in the order returned by rollback, latest block first.
+func (s *State) restore(ancestor uint64, orphaned []database.Block) {
+	s.evHandler("state: restore: common-blknum[%d]: numBlocks[%d]", ancestor, len(orphaned))
+
+	if _, err := s.rollback(ancestor); err != nil {
+		s.evHandler("state: restore: ERROR: %s", err)
+		return
+	}
+
+	for i := len(orphaned) - 1; i >= 0; i-- {
+		if err := s.validateUpdateDatabase(orphaned[i]); err != nil {
+			s.evHandler("state: restore: blk[%d]: ERROR: %s", orphaned[i].Header.Number, err)
+			return
+		}
+	}
+}
+
+// rollback undoes the blocks after the specified block number and puts
+// their transactions back into the mempool. Transactions that are part of
+// the peer's branch are removed again as its blocks are applied. The blocks
+// that were undone are returned, latest block first.
+func (s *State) rollback(num uint64) ([]database.Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blocks, err := s.db.Rollback(num)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, block := range blocks {
+		s.evHandler("state: rollback: blk[%d]: orphaned: numTrans[%d]", block.Header.Number, len(block.MerkleTree.Values()))
+
+		for _, tx := range block.MerkleTree.Values() {
+			if err := s.mempool.Upsert(tx); err != nil {
+				s.evHandler("state: rollback: tx[%s]: WARNING: %s", tx, err)
+			}
+		}
+	}
+
+	// The nonces of the accounts went back, so their later transactions
+	// wait for the orphaned transactions to be mined again.
+	s.mempool.UpdateLanes()
+
+	return blocks, nil
+}
+
+// heaviestPeer queries the known peers and returns the peer that is holding
+// the chain with the most work, as long as that chain holds more work than
+// ours. Peers that don't report their total work are skipped.
+func (s *State) heaviestPeer() (peer.Peer, peer.PeerStatus, error) {
+	var heaviest peer.Peer
+	var heaviestStatus peer.PeerStatus
+	var found bool
+
+	for _, pr := range s.KnownExternalPeers() {
+		status, err := s.NetRequestPeerStatus(pr)
+		if err != nil {
+			s.evHandler("state: heaviestPeer: %s: ERROR: %s", pr, err)
+			continue
+		}
+
+		if status.TotalWork == nil {
+			s.evHandler("state: heaviestPeer: %s: no total work reported", pr)
+			continue
+		}
+
+		if !found || status.TotalWork.Cmp(heaviestStatus.TotalWork) > 0 {
+			heaviest = pr
+			heaviestStatus = status
+			found = true
+		}
+	}
+
+	if !found || heaviestStatus.TotalWork.Cmp(s.db.TotalWork()) <= 0 {
+		return peer.Peer{}, peer.PeerStatus{}, errors.New("no peer has a chain with more work")
+	}
+
+	return heaviest, heaviestStatus, nil
+}
+
 // =============================================================================
 
 // validateUpdateDatabase takes the block and validates the block against the
@@ -84,7 +517,12 @@ func (s *State) validateUpdateDatabase(block database.Block) error {
 	// me to this function for the same block number, I could replace the peer
 	// block with my own and attempt to have other peers accept my block instead.
 
-	if err := block.ValidateBlock(s.db.LatestBlock(), s.db.HashState(), s.evHandler); err != nil {
+	difficulty, err := s.db.NextDifficulty()
+	if err != nil {
+		return err
+	}
+
+	if err := block.ValidateBlock(s.db.LatestBlock(), s.db.HashState(), difficulty, s.db.Rules(), s.evHandler); err != nil {
 		return err
 	}
 
@@ -112,6 +550,9 @@ func (s *State) validateUpdateDatabase(block database.Block) error {
 		}
 	}
 
+	// Promote the transactions whose nonce gap was filled by this block.
+	s.mempool.UpdateLanes()
+
 	s.evHandler("state: validateUpdateDatabase: apply mining reward")
 
 	// Apply the mining reward for this block.
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+)
+
+// baseURL represents the base URL for the private node API.
+const baseURL = "http://%s/v1/node"
+
+// netTimeout is the maximum amount of time a single peer request can take.
+const netTimeout = 5 * time.Second
+
+// =============================================================================
+
+// NetSendBlockToPeers takes the new mined block and sends it to all known peers.
+// A peer that doesn't accept the block is logged and skipped so the rest of
+// the network still receives it.
+func (s *State) NetSendBlockToPeers(block database.Block) {
+	s.evHandler("state: NetSendBlockToPeers: started")
+	defer s.evHandler("state: NetSendBlockToPeers: completed")
+
+	for _, pr := range s.KnownExternalPeers() {
+		s.evHandler("state: NetSendBlockToPeers: send: block[%s] to peer[%s]", block.Hash(), pr)
+
+		url := fmt.Sprintf("%s/block/propose", fmt.Sprintf(baseURL, pr.Host))
+
+		var status struct {
+			Status string `json:"status"`
+		}
+		if err := send(http.MethodPost, url, database.NewBlockData(block), &status); err != nil {
+			s.evHandler("state: NetSendBlockToPeers: WARNING: peer[%s]: %s", pr, err)
+			continue
+		}
+	}
+}
+
+// NetSendTxToPeers shares a new transaction accepted into the mempool with
+// the known peers.
+func (s *State) NetSendTxToPeers(tx database.BlockTx) {
+	s.evHandler("state: NetSendTxToPeers: started")
+	defer s.evHandler("state: NetSendTxToPeers: completed")
+
+	for _, pr := range s.KnownExternalPeers() {
+		s.evHandler("state: NetSendTxToPeers: send: tx[%s] to peer[%s]", tx, pr)
+
+		url := fmt.Sprintf("%s/tx/submit", fmt.Sprintf(baseURL, pr.Host))
+
+		if err := send(http.MethodPost, url, tx, nil); err != nil {
+			s.evHandler("state: NetSendTxToPeers: WARNING: peer[%s]: %s", pr, err)
+			continue
+		}
+	}
+}
+
+// NetRequestPeerStatus asks the specified peer for its latest block and
+// the list of peers it knows about.
+func (s *State) NetRequestPeerStatus(pr peer.Peer) (peer.PeerStatus, error) {
+	s.evHandler("state: NetRequestPeerStatus: started: %s", pr)
+	defer s.evHandler("state: NetRequestPeerStatus: completed: %s", pr)
+
+	url := fmt.Sprintf("%s/status", fmt.Sprintf(baseURL, pr.Host))
+
+	var ps peer.PeerStatus
+	if err := send(http.MethodGet, url, nil, &ps); err != nil {
+		return peer.PeerStatus{}, err
+	}
+
+	s.evHandler("state: NetRequestPeerStatus: peer-node[%s]: latest-blknum[%d]: peer-list[%s]", pr, ps.LatestBlockNumber, ps.KnownPeers)
+
+	return ps, nil
+}
+
+// NetRequestPeerBlocks queries the specified node asking for the blocks
+// in the specified range.
+func (s *State) NetRequestPeerBlocks(pr peer.Peer, from uint64, to uint64) ([]database.Block, error) {
+	s.evHandler("state: NetRequestPeerBlocks: started: %s: from[%d]: to[%d]", pr, from, to)
+	defer s.evHandler("state: NetRequestPeerBlocks: completed: %s", pr)
+
+	url := fmt.Sprintf("%s/block/list/%d/%d", fmt.Sprintf(baseURL, pr.Host), from, to)
+
+	var blocksData []database.BlockData
+	if err := send(http.MethodGet, url, nil, &blocksData); err != nil {
+		return nil, err
+	}
+
+	s.evHandler("state: NetRequestPeerBlocks: found blocks[%d]", len(blocksData))
+
+	blocks := make([]database.Block, len(blocksData))
+	for i, blockData := range blocksData {
+		block, err := database.ToBlock(blockData)
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = block
+	}
+
+	return blocks, nil
+}
+
+// =============================================================================
+
+// send is a helper function to send an HTTP request to a node.
+func send(method string, url string, dataSend any, dataRecv any) error {
+	var body io.Reader
+	if dataSend != nil {
+		data, err := json.Marshal(dataSend)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{
+		Timeout: netTimeout,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(msg))
+	}
+
+	if dataRecv != nil {
+		if err := json.NewDecoder(resp.Body).Decode(dataRecv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,8 +1,117 @@
 package state
 
-import "github.com/ardanlabs/blockchain/foundation/blockchain/database"
+import (
+	"fmt"
+	"math"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// QueryLatest represents to query the latest block in the chain.
+const QueryLatest = math.MaxUint64
+
+// =============================================================================
 
 // QueryAccount returns a copy of the account from the database.
 func (s *State) QueryAccount(account database.AccountID) (database.Account, error) {
 	return s.db.Query(account)
 }
+
+// QueryBlockByNumber returns the block with the specified number. This
+// function reads the blockchain from storage.
+func (s *State) QueryBlockByNumber(num uint64) (database.Block, error) {
+	latestNumber := s.db.LatestBlock().Header.Number
+	if num == QueryLatest {
+		num = latestNumber
+	}
+	if num == 0 || num > latestNumber {
+		return database.Block{}, fmt.Errorf("block %d does not exist", num)
+	}
+
+	return s.db.GetBlock(num)
+}
+
+// QueryBlocksByNumber returns the set of blocks based on block numbers. This
+// function reads the blockchain from storage.
+func (s *State) QueryBlocksByNumber(from uint64, to uint64) []database.Block {
+	latestNumber := s.db.LatestBlock().Header.Number
+	if from == QueryLatest {
+		from = latestNumber
+	}
+	if to == QueryLatest || to > latestNumber {
+		to = latestNumber
+	}
+	if from == 0 {
+		from = 1
+	}
+
+	var out []database.Block
+	for i := from; i <= to; i++ {
+		block, err := s.db.GetBlock(i)
+		if err != nil {
+			s.evHandler("state: QueryBlocksByNumber: ERROR: blk[%d]: %s", i, err)
+			return out
+		}
+		out = append(out, block)
+	}
+
+	return out
+}
+
+// QueryHeadersByNumber returns the set of signed block headers based on block
+// numbers. This function reads the headers from storage, which are kept for
+// blocks that have been pruned. The block data has no transactions.
+func (s *State) QueryHeadersByNumber(from uint64, to uint64) []database.BlockData {
+	latestNumber := s.db.LatestBlock().Header.Number
+	if from == QueryLatest {
+		from = latestNumber
+	}
+	if to == QueryLatest || to > latestNumber {
+		to = latestNumber
+	}
+	if from == 0 {
+		from = 1
+	}
+
+	var out []database.BlockData
+	for i := from; i <= to; i++ {
+		header, err := s.db.GetSignedHeader(i)
+		if err != nil {
+			s.evHandler("state: QueryHeadersByNumber: ERROR: blk[%d]: %s", i, err)
+			return out
+		}
+		out = append(out, header)
+	}
+
+	return out
+}
+
+// QueryBlockByHash returns the block with the specified hash. This function
+// uses the index to locate the block in storage.
+func (s *State) QueryBlockByHash(hash string) (database.Block, error) {
+	return s.db.GetBlockByHash(hash)
+}
+
+// QueryBlocksByAccount returns the set of blocks that contain transactions
+// sent or received by the specified account. This function uses the index to
+// locate the blocks in storage. Blocks whose transactions have been pruned
+// are skipped.
+func (s *State) QueryBlocksByAccount(accountID database.AccountID) []database.Block {
+	var out []database.Block
+	var prev uint64
+	for _, loc := range s.db.GetAccountTransactions(accountID) {
+		if loc.BlockNumber == prev {
+			continue
+		}
+		prev = loc.BlockNumber
+
+		block, err := s.db.GetBlock(loc.BlockNumber)
+		if err != nil {
+			s.evHandler("state: QueryBlocksByAccount: ERROR: blk[%d]: %s", loc.BlockNumber, err)
+			continue
+		}
+		out = append(out, block)
+	}
+
+	return out
+}
@@ -3,10 +3,16 @@
 package state
 
 import (
+	"crypto/ecdsa"
+	"errors"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+	"math/big"
+	"runtime"
 	"sync"
+	"time"
 )
 
 // EventHandler defines a function that is called when events 之前说的事件处理函数 用作日志记录的 在接口里面作为函数参数
@@ -19,6 +25,7 @@ type Worker interface {
 	Shutdown()
 	SignalStartMining()
 	SignalCancelMining()
+	SignalShareTx(blockTx database.BlockTx)
 }
 
 // =============================================================================
@@ -27,9 +34,19 @@ type Worker interface {
 // the blockchain node.
 type Config struct {
 	BeneficiaryID  database.AccountID //受益人以太坊地址
+	BeneficiaryKey *ecdsa.PrivateKey
+	Host           string
+	KnownPeers     *peer.PeerSet
 	Storage        database.Storage
+	Index          database.Indexer     // Required, an index with no path is kept in memory.
+	Snapshots      database.Snapshotter // Nil disables account snapshots.
 	Genesis        genesis.Genesis
 	SelectStrategy string
+	MempoolLimits  mempool.Limits   // Zero values impose no limits.
+	MempoolTTL     time.Duration    // Time a transaction is held in the mempool, 0 holds it until it's mined.
+	MempoolJournal *mempool.Journal // Nil keeps the mempool in memory only.
+	Consensus      string
+	MiningWorkers  int // Number of goroutines searching for a nonce, 0 uses one per CPU.
 	EvHandler      EventHandler
 }
 
@@ -38,9 +55,16 @@ type State struct {
 	mu          sync.RWMutex
 	resyncWG    sync.WaitGroup
 	allowMining bool
+	resyncing   bool
 
-	beneficiaryID database.AccountID
-	evHandler     EventHandler
+	beneficiaryID  database.AccountID
+	beneficiaryKey *ecdsa.PrivateKey
+	host           string
+	knownPeers     *peer.PeerSet
+	consensus      string
+	miningWorkers  int
+	mempoolTTL     time.Duration
+	evHandler      EventHandler
 
 	storage database.Storage
 	genesis genesis.Genesis
@@ -60,29 +84,72 @@ func New(cfg Config) (*State, error) {
 		}
 	}
 
+	// The beneficiary key is required to sign the blocks this node produces.
+	if cfg.BeneficiaryKey == nil || database.PublicKeyToAccountID(cfg.BeneficiaryKey.PublicKey) != cfg.BeneficiaryID {
+		return nil, errors.New("beneficiary key doesn't match the beneficiary account")
+	}
+
 	// Access the storage for the blockchain.
-	db, err := database.New(cfg.Genesis, cfg.Storage, ev)
+	db, err := database.New(cfg.Genesis, cfg.Storage, cfg.Index, cfg.Snapshots, cfg.Consensus, ev)
 	if err != nil {
 		return nil, err
 	}
 
-	// Construct a mempool with the specified sort strategy.
-	mempool, err := mempool.NewWithStrategy(cfg.SelectStrategy)
+	// Search for a nonce on every CPU unless told otherwise.
+	miningWorkers := cfg.MiningWorkers
+	if miningWorkers <= 0 {
+		miningWorkers = runtime.NumCPU()
+	}
+
+	// Read the transactions that were in the mempool when the node stopped.
+	var journaled []database.BlockTx
+	if cfg.MempoolJournal != nil {
+		if journaled, err = cfg.MempoolJournal.Load(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Construct a mempool with the specified sort strategy and limits. The
+	// account nonces decide which transactions can be mined.
+	mempool, err := mempool.NewWithConfig(mempool.Config{
+		Strategy: cfg.SelectStrategy,
+		Limits:   cfg.MempoolLimits,
+		Nonce: func(accountID database.AccountID) uint64 {
+			account, _ := db.Query(accountID)
+			return account.Nonce
+		},
+		Journal:   cfg.MempoolJournal,
+		EvHandler: ev,
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	// Create the State to provide support for managing the blockchain.
 	state := State{
-		storage:       cfg.Storage,
-		beneficiaryID: cfg.BeneficiaryID,
-		evHandler:     ev,
-		allowMining:   true,
+		storage:        cfg.Storage,
+		beneficiaryID:  cfg.BeneficiaryID,
+		beneficiaryKey: cfg.BeneficiaryKey,
+		host:           cfg.Host,
+		knownPeers:     cfg.KnownPeers,
+		consensus:      db.Rules().Consensus,
+		miningWorkers:  miningWorkers,
+		mempoolTTL:     cfg.MempoolTTL,
+		evHandler:      ev,
+		allowMining:    true,
 
 		mempool: mempool,
 		genesis: cfg.Genesis,
 		db:      db,
 	}
+
+	// Readmit the journaled transactions that are still valid.
+	if cfg.MempoolJournal != nil {
+		if err := state.reloadMempool(journaled); err != nil {
+			return nil, err
+		}
+	}
+
 	return &state, nil
 }
 
@@ -91,9 +158,10 @@ func (s *State) Shutdown() error {
 	s.evHandler("state: shutdown: started")
 	defer s.evHandler("state: shutdown: completed")
 
-	// Make sure the database file is properly closed.
+	// Make sure the database and mempool files are properly closed.
 	defer func() {
 		s.db.Close()
+		s.mempool.Close()
 	}()
 
 	// Stop all blockchain writing activity.
@@ -116,10 +184,36 @@ func (s *State) IsMiningAllowed() bool {
 	return s.allowMining
 }
 
+// TurnMiningOn sets the allowMining flag back to true.
+func (s *State) TurnMiningOn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.allowMining = true
+}
+
+// TurnMiningOff sets the allowMining flag to false.
+func (s *State) TurnMiningOff() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.allowMining = false
+}
+
+// IsResyncing identifies if the blockchain is currently being re-synced
+// with a peer after a fork was detected.
+func (s *State) IsResyncing() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.resyncing
+}
+
 // ====================================mempool api===========================================================
-// MempoolLength returns the current length of the mempool.
+// MempoolLength returns the number of transactions in the mempool that can
+// be mined.
 func (s *State) MempoolLength() int {
-	return s.mempool.Count()
+	return s.mempool.PendingCount()
 }
 
 // Mempool returns a copy of the mempool.因为我们没传值
@@ -127,6 +221,29 @@ func (s *State) Mempool() []database.BlockTx {
 	return s.mempool.PickBest()
 }
 
+// MempoolTTL returns the time a transaction is held in the mempool before
+// it expires. A value of 0 means transactions don't expire.
+func (s *State) MempoolTTL() time.Duration {
+	return s.mempoolTTL
+}
+
+// ExpireMempool removes the transactions that have been held in the mempool
+// longer than the TTL. The removed transactions are returned.
+func (s *State) ExpireMempool() []database.BlockTx {
+	if s.mempoolTTL <= 0 {
+		return nil
+	}
+
+	before := time.Now().UTC().Add(-s.mempoolTTL).UnixMilli()
+	return s.mempool.Expire(uint64(before))
+}
+
+// MempoolQueued returns a copy of the transactions in the mempool that are
+// waiting for an earlier nonce of their account.
+func (s *State) MempoolQueued() []database.BlockTx {
+	return s.mempool.Queued()
+}
+
 // UpsertMempool adds a new transaction to the mempool.
 func (s *State) UpsertMempool(tx database.BlockTx) error {
 	return s.mempool.Upsert(tx)
@@ -146,3 +263,32 @@ func (s *State) Genesis() genesis.Genesis {
 func (s *State) LatestBlock() database.Block {
 	return s.db.LatestBlock()
 }
+
+// TotalWork returns the total work of the blocks in the chain.
+func (s *State) TotalWork() *big.Int {
+	return s.db.TotalWork()
+}
+
+// =============================================================================
+
+// Consensus returns a copy of consensus algorithm being used.
+func (s *State) Consensus() string {
+	return s.consensus
+}
+
+// Host returns a copy of host information.
+func (s *State) Host() string {
+	return s.host
+}
+
+// KnownExternalPeers retrieves a copy of the known peer list without
+// including this node.
+func (s *State) KnownExternalPeers() []peer.Peer {
+	return s.knownPeers.Copy(s.host)
+}
+
+// AddKnownPeer provides the ability to add a new peer to
+// the known peer list. It returns true if the peer was new.
+func (s *State) AddKnownPeer(peer peer.Peer) bool {
+	return s.knownPeers.Add(peer)
+}
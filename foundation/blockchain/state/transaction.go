@@ -1,16 +1,31 @@
 package state
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool"
+)
+
+// oneUnitOfGas represents the number of gas units charged for each transaction.
+const oneUnitOfGas = 1
+
+// Set of errors returned when a wallet transaction is sure to fail once it's
+// mined into a block.
+var (
+	ErrNonceTooLow       = errors.New("nonce has already been used")
+	ErrInsufficientFunds = errors.New("insufficient funds")
 )
 
 // UpsertWalletTransaction accepts a transaction from a wallet for inclusion.
 func (s *State) UpsertWalletTransaction(signedTx database.SignedTx) error {
 
-	// CORE NOTE: It's up to the wallet to make sure the account has a proper
-	// balance and this transaction has a proper nonce. Fees will be taken if
-	// this transaction is mined into a block it doesn't have enough money to
-	// pay or the nonce isn't the next expected nonce for the account.
+	// CORE NOTE: Fees are taken if a transaction is mined into a block and the
+	// account doesn't have enough money to pay or the nonce isn't the next
+	// expected nonce for the account. A wallet transaction that is sure to
+	// fail is rejected before it gets into the mempool, so the account isn't
+	// charged gas for it.
 	//钱包必须确保账户有足够的余额来支付交易费用。
 	//交易的 nonce 必须是账户的下一个预期 nonce。
 	//如果交易在被挖掘到区块中时，账户余额不足以支付费用，或者 nonce 不正确，交易将会失败。
@@ -21,8 +36,11 @@ func (s *State) UpsertWalletTransaction(signedTx database.SignedTx) error {
 		return err
 	}
 
-	const oneUnitOfGas = 1
 	tx := database.NewBlockTx(signedTx, s.genesis.GasPrice, oneUnitOfGas)
+	if err := s.checkAccount(tx); err != nil {
+		return err
+	}
+
 	if err := s.mempool.Upsert(tx); err != nil {
 		return err
 	}
@@ -37,7 +55,141 @@ func (s *State) UpsertWalletTransaction(signedTx database.SignedTx) error {
 	//}
 
 	// now we use worker to signal the behavior above 去替代hack 用另一种创建线程的方式去触发挖矿的行为
+	s.Worker.SignalShareTx(tx)
 	s.Worker.SignalStartMining()
 
 	return nil
 }
+
+// UpsertNodeTransaction accepts a transaction from a peer node for inclusion.
+// A transaction this node already holds, recently mined or expired is ignored
+// and not shared again, which stops the same transaction from bouncing
+// between nodes.
+func (s *State) UpsertNodeTransaction(tx database.BlockTx) error {
+
+	// Check the signed transaction has a proper signature, the from matches the
+	// signature, and the from and to fields are properly formatted.
+	if err := tx.Validate(s.genesis.ChainID); err != nil {
+		return err
+	}
+
+	// A transaction that was already mined into a block or expired is not
+	// accepted again or it would keep bouncing between the nodes.
+	if s.mempool.RecentlyRemoved(tx) {
+		s.evHandler("state: UpsertNodeTransaction: tx[%s] already mined or expired", tx)
+		return nil
+	}
+
+	// The gas values are set by this node and not trusted from the peer.
+	tx.GasPrice = s.genesis.GasPrice
+	tx.GasUnits = oneUnitOfGas
+
+	if err := s.mempool.Upsert(tx); err != nil {
+		if errors.Is(err, mempool.ErrTxExists) {
+			s.evHandler("state: UpsertNodeTransaction: tx[%s] already known", tx)
+			return nil
+		}
+		return err
+	}
+
+	s.Worker.SignalShareTx(tx)
+	s.Worker.SignalStartMining()
+
+	return nil
+}
+
+// =============================================================================
+
+// reloadMempool readmits the transactions journaled by the mempool before the
+// node restarted. Blocks may have been written since, so each transaction is
+// validated again like a new transaction before it's readmitted.
+func (s *State) reloadMempool(trans []database.BlockTx) error {
+	var readmitted int
+	for _, tx := range trans {
+		if err := s.readmitTransaction(tx); err != nil {
+			s.evHandler("state: reloadMempool: tx[%s]: DROPPED: %s", tx, err)
+			continue
+		}
+		readmitted++
+	}
+
+	s.evHandler("state: reloadMempool: readmitted[%d] of [%d] transactions", readmitted, len(trans))
+
+	// Drop the transactions that weren't readmitted from the journal.
+	return s.mempool.CompactJournal()
+}
+
+// readmitTransaction validates a journaled transaction against the genesis
+// and the accounts and adds it back into the mempool.
+func (s *State) readmitTransaction(tx database.BlockTx) error {
+	if err := tx.Validate(s.genesis.ChainID); err != nil {
+		return err
+	}
+
+	// The gas values are set by this node and the genesis may have changed.
+	tx.GasPrice = s.genesis.GasPrice
+	tx.GasUnits = oneUnitOfGas
+
+	if err := s.checkAccount(tx); err != nil {
+		return err
+	}
+
+	return s.mempool.Upsert(tx)
+}
+
+// checkAccount makes sure the transaction can be applied on top of the
+// account in the database and the account's transactions already held in the
+// mempool. The nonce has to be unused and the balance has to cover the value,
+// tip and gas of the held transactions before it and of itself. A transaction
+// that replaces a held transaction takes its place. A transaction with a gap
+// before its nonce is accepted and waits in the mempool's queued lane.
+func (s *State) checkAccount(tx database.BlockTx) error {
+	account, err := s.db.Query(tx.FromID)
+	if err != nil {
+		account = database.Account{AccountID: tx.FromID}
+	}
+
+	if tx.Nonce <= account.Nonce {
+		return fmt.Errorf("%w: got %d, account nonce %d", ErrNonceTooLow, tx.Nonce, account.Nonce)
+	}
+
+	balance := account.Balance
+	nextNonce := account.Nonce + 1
+
+	for _, held := range s.mempool.AccountTxs(tx.FromID) {
+		if held.Nonce != nextNonce {
+			continue
+		}
+		nextNonce++
+
+		if held.Nonce == tx.Nonce {
+			held = tx
+		}
+
+		var ok bool
+		if balance, ok = spend(balance, held); !ok {
+			return fmt.Errorf("%w: bal %d doesn't cover the transactions up to nonce %d", ErrInsufficientFunds, account.Balance, held.Nonce)
+		}
+	}
+
+	if tx.Nonce >= nextNonce {
+		if _, ok := spend(balance, tx); !ok {
+			return fmt.Errorf("%w: bal %d doesn't cover the transactions up to nonce %d", ErrInsufficientFunds, account.Balance, tx.Nonce)
+		}
+	}
+
+	return nil
+}
+
+// spend takes the gas, value and tip of the transaction from the balance.
+// False is returned if the balance doesn't cover them.
+func spend(balance uint64, tx database.BlockTx) (uint64, bool) {
+	for _, amount := range []uint64{tx.GasPrice * tx.GasUnits, tx.Value, tx.Tip} {
+		if amount > balance {
+			return 0, false
+		}
+		balance -= amount
+	}
+
+	return balance, true
+}
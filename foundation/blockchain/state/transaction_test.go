@@ -0,0 +1,184 @@
+package state_test
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/index"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/memory"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// to is the account receiving the transactions in the tests.
+const to = database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+// noWorker represents a worker that ignores the signals it's sent.
+type noWorker struct{}
+
+func (noWorker) Shutdown()                              {}
+func (noWorker) SignalStartMining()                     {}
+func (noWorker) SignalCancelMining()                    {}
+func (noWorker) SignalShareTx(blockTx database.BlockTx) {}
+
+// newKey constructs a new private key.
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	return key
+}
+
+// newState constructs the state of a POA chain kept in memory where the
+// sender holds the specified balance.
+func newState(t *testing.T, sender *ecdsa.PrivateKey, balance uint64) *state.State {
+	beneficiary := newKey(t)
+	beneficiaryID := database.PublicKeyToAccountID(beneficiary.PublicKey)
+
+	storage, err := memory.New()
+	if err != nil {
+		t.Fatalf("constructing storage: %v", err)
+	}
+
+	idx, err := index.New("")
+	if err != nil {
+		t.Fatalf("constructing index: %v", err)
+	}
+
+	st, err := state.New(state.Config{
+		BeneficiaryID:  beneficiaryID,
+		BeneficiaryKey: beneficiary,
+		Storage:        storage,
+		Index:          idx,
+		Genesis: genesis.Genesis{
+			ChainID:     1,
+			GasPrice:    10,
+			Authorities: []string{string(beneficiaryID)},
+			Balances:    map[string]uint64{string(database.PublicKeyToAccountID(sender.PublicKey)): balance},
+		},
+		SelectStrategy: selector.StrategyTip,
+		Consensus:      database.ConsensusPOA,
+	})
+	if err != nil {
+		t.Fatalf("constructing state: %v", err)
+	}
+	st.Worker = noWorker{}
+
+	return st
+}
+
+// walletTx represents the values of a transaction sent by a wallet.
+type walletTx struct {
+	nonce uint64
+	value uint64
+	tip   uint64
+}
+
+// newSignedTx constructs a transaction from the sender signed by its key.
+func newSignedTx(t *testing.T, sender *ecdsa.PrivateKey, tx walletTx) database.SignedTx {
+	fromID := database.PublicKeyToAccountID(sender.PublicKey)
+
+	dbTx, err := database.NewTx(1, tx.nonce, fromID, to, tx.value, tx.tip, nil)
+	if err != nil {
+		t.Fatalf("constructing tx: %v", err)
+	}
+
+	signedTx, err := dbTx.Sign(sender)
+	if err != nil {
+		t.Fatalf("signing tx: %v", err)
+	}
+
+	return signedTx
+}
+
+// replaces reports whether the transaction has the nonce of a held transaction.
+func replaces(held []walletTx, tx walletTx) bool {
+	for _, h := range held {
+		if h.nonce == tx.nonce {
+			return true
+		}
+	}
+
+	return false
+}
+
+// =============================================================================
+
+func Test_UpsertWalletTransaction(t *testing.T) {
+	// The sender holds 1000 and every transaction pays 10 in gas.
+	table := []struct {
+		name   string
+		held   []walletTx
+		tx     walletTx
+		expErr error
+	}{
+		{name: "next nonce", tx: walletTx{nonce: 1, value: 100, tip: 10}},
+		{name: "used nonce", tx: walletTx{nonce: 0, value: 100, tip: 10}, expErr: state.ErrNonceTooLow},
+		{name: "nonce gap waits", tx: walletTx{nonce: 3, value: 100, tip: 10}},
+		{name: "spends the balance", tx: walletTx{nonce: 1, value: 980, tip: 10}},
+		{name: "value over the balance", tx: walletTx{nonce: 1, value: 981, tip: 10}, expErr: state.ErrInsufficientFunds},
+		{
+			name: "held transactions covered",
+			held: []walletTx{{nonce: 1, value: 100, tip: 10}, {nonce: 2, value: 100, tip: 10}},
+			tx:   walletTx{nonce: 3, value: 500, tip: 10},
+		},
+		{
+			name:   "held transactions drain the balance",
+			held:   []walletTx{{nonce: 1, value: 400, tip: 10}, {nonce: 2, value: 400, tip: 10}},
+			tx:     walletTx{nonce: 3, value: 200, tip: 10},
+			expErr: state.ErrInsufficientFunds,
+		},
+		{
+			name: "replacement takes its place",
+			held: []walletTx{{nonce: 1, value: 900, tip: 10}},
+			tx:   walletTx{nonce: 1, value: 100, tip: 11},
+		},
+		{
+			name:   "replacement too expensive",
+			held:   []walletTx{{nonce: 1, value: 100, tip: 10}, {nonce: 2, value: 700, tip: 10}},
+			tx:     walletTx{nonce: 1, value: 300, tip: 11},
+			expErr: state.ErrInsufficientFunds,
+		},
+		{
+			name: "queued transactions not counted",
+			held: []walletTx{{nonce: 1, value: 100, tip: 10}, {nonce: 3, value: 800, tip: 10}},
+			tx:   walletTx{nonce: 2, value: 500, tip: 10},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := newKey(t)
+			st := newState(t, sender, 1000)
+
+			for _, held := range tt.held {
+				if err := st.UpsertWalletTransaction(newSignedTx(t, sender, held)); err != nil {
+					t.Fatalf("upserting held nonce %d: %v", held.nonce, err)
+				}
+			}
+
+			err := st.UpsertWalletTransaction(newSignedTx(t, sender, tt.tx))
+			if tt.expErr == nil && err != nil {
+				t.Fatalf("expected the transaction to be accepted, got %v", err)
+			}
+			if tt.expErr != nil && !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected error %v, got %v", tt.expErr, err)
+			}
+
+			// A rejected transaction leaves the held transactions alone.
+			exp := len(tt.held)
+			if err == nil && !replaces(tt.held, tt.tx) {
+				exp++
+			}
+			if got := len(st.Mempool()) + len(st.MempoolQueued()); got != exp {
+				t.Errorf("expected %d transactions in the mempool, got %d", exp, got)
+			}
+		})
+	}
+}
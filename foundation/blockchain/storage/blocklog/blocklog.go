@@ -0,0 +1,459 @@
+// Package blocklog implements the ability to read and write blocks to disk
+// by appending them as records to a log split across segment files.
+package blocklog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+)
+
+// maxSegmentSize is the size a segment file can grow to before a new
+// segment file is started.
+const maxSegmentSize = 64 << 20
+
+// recordHeaderSize is the size of the header in front of every record. The
+// header holds the length of the block data and its checksum.
+const recordHeaderSize = 8
+
+// segmentExt is the file extension used for the segment files.
+const segmentExt = ".seg"
+
+// errBlockNotExist is returned when a block number past the end of the
+// chain is requested.
+var errBlockNotExist = errors.New("block does not exist")
+
+// crcTable is used to checksum the block data in every record.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// =============================================================================
+
+// BlockLog represents the serialization implementation for reading and storing
+// blocks as records appended to segment files on disk. Each record is the
+// block data prefixed by its length and checksum. This implements the
+// database.Storage interface.
+type BlockLog struct {
+	mu       sync.RWMutex
+	dbPath   string
+	codec    codec.Codec
+	segments []*segment
+	index    []location // Location of each block, the block number is the index + 1.
+}
+
+// segment represents a single segment file of the log.
+type segment struct {
+	first uint64 // Block number of the first record in the segment.
+	file  *os.File
+	size  int64 // Size of the valid records in the segment.
+}
+
+// location represents where the record for a block is stored.
+type location struct {
+	segment int
+	offset  int64
+	length  uint32
+}
+
+// New constructs a BlockLog value for use. The codec determines how the
+// blocks are encoded in the records. The segment files are scanned to rebuild
+// the index of blocks. A bad record at the end of the log, left by a crash in
+// the middle of a write, is truncated. Any other bad record is corruption and
+// is returned as an error.
+func New(dbPath string, codec codec.Codec) (*BlockLog, error) {
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return nil, err
+	}
+
+	bl := BlockLog{
+		dbPath: dbPath,
+		codec:  codec,
+	}
+
+	if err := bl.open(); err != nil {
+		bl.Close()
+		return nil, err
+	}
+
+	return &bl, nil
+}
+
+// Close closes the segment files.
+func (bl *BlockLog) Close() error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	return bl.close()
+}
+
+// Write takes the specified database block and appends it to the log.
+func (bl *BlockLog) Write(blockData database.BlockData) error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	if uint64(len(bl.index))+1 != blockData.Header.Number {
+		return errors.New("block is out of order")
+	}
+
+	data, err := bl.codec.Encode(blockData)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > maxSegmentSize {
+		return fmt.Errorf("block is too large, %d bytes", len(data))
+	}
+
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(data, crcTable))
+	copy(record[recordHeaderSize:], data)
+
+	// Start a new segment once the current one is full.
+	seg := bl.segments[len(bl.segments)-1]
+	if seg.size > 0 && seg.size+int64(len(record)) > maxSegmentSize {
+		if seg, err = bl.createSegment(blockData.Header.Number); err != nil {
+			return err
+		}
+	}
+
+	// Write the record and make sure it's on disk before it's indexed. On a
+	// failure, drop what was written so the next write starts clean.
+	if _, err := seg.file.WriteAt(record, seg.size); err != nil {
+		seg.file.Truncate(seg.size)
+		return err
+	}
+	if err := seg.file.Sync(); err != nil {
+		seg.file.Truncate(seg.size)
+		return err
+	}
+
+	bl.index = append(bl.index, location{
+		segment: len(bl.segments) - 1,
+		offset:  seg.size,
+		length:  uint32(len(data)),
+	})
+	seg.size += int64(len(record))
+
+	return nil
+}
+
+// GetBlock uses the index to locate and return the contents of the
+// specified block by number.
+func (bl *BlockLog) GetBlock(num uint64) (database.BlockData, error) {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+
+	if num == 0 || num > uint64(len(bl.index)) {
+		return database.BlockData{}, errBlockNotExist
+	}
+
+	loc := bl.index[num-1]
+	seg := bl.segments[loc.segment]
+
+	r := io.NewSectionReader(seg.file, loc.offset, recordHeaderSize+int64(loc.length))
+	data, err := readRecord(r)
+	if err != nil {
+		return database.BlockData{}, fmt.Errorf("block %d: %w", num, err)
+	}
+
+	blockData, err := bl.codec.Decode(data)
+	if err != nil {
+		return database.BlockData{}, fmt.Errorf("block %d: %w", num, err)
+	}
+
+	return blockData, nil
+}
+
+// ForEach returns an iterator to walk through all the blocks
+// starting with block number 1.
+func (bl *BlockLog) ForEach() database.Iterator {
+	return &blockLogIterator{storage: bl}
+}
+
+// ForEachFrom returns an iterator to walk through the blocks starting
+// with the specified block number.
+func (bl *BlockLog) ForEachFrom(num uint64) database.Iterator {
+	return &blockLogIterator{storage: bl, current: num - 1}
+}
+
+// Reset will clear out the blockchain on disk.
+func (bl *BlockLog) Reset() error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	if err := bl.close(); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(bl.dbPath); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(bl.dbPath, 0755); err != nil {
+		return err
+	}
+
+	_, err := bl.createSegment(1)
+	return err
+}
+
+// Truncate removes all the blocks after the specified block number.
+func (bl *BlockLog) Truncate(num uint64) error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	if num >= uint64(len(bl.index)) {
+		return nil
+	}
+
+	loc := bl.index[num]
+
+	// Remove the segments that only hold blocks being removed.
+	for i := len(bl.segments) - 1; i > loc.segment; i-- {
+		seg := bl.segments[i]
+		seg.file.Close()
+		if err := os.Remove(seg.file.Name()); err != nil {
+			return err
+		}
+		bl.segments = bl.segments[:i]
+	}
+
+	seg := bl.segments[loc.segment]
+	if err := seg.file.Truncate(loc.offset); err != nil {
+		return err
+	}
+	if err := seg.file.Sync(); err != nil {
+		return err
+	}
+
+	seg.size = loc.offset
+	bl.index = bl.index[:num]
+
+	return nil
+}
+
+// =============================================================================
+
+// open scans the existing segment files to rebuild the index.
+func (bl *BlockLog) open() error {
+	names, err := filepath.Glob(filepath.Join(bl.dbPath, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+
+	// The file names are padded block numbers so they sort in block order.
+	sort.Strings(names)
+
+	for i, name := range names {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+		if err != nil {
+			return fmt.Errorf("segment %s: invalid name: %w", name, err)
+		}
+
+		if first != uint64(len(bl.index))+1 {
+			return fmt.Errorf("segment %s: starts at block %d, exp %d", name, first, len(bl.index)+1)
+		}
+
+		f, err := os.OpenFile(name, os.O_RDWR, 0600)
+		if err != nil {
+			return err
+		}
+		bl.segments = append(bl.segments, &segment{first: first, file: f})
+
+		if err := bl.loadSegment(len(bl.segments)-1, i == len(names)-1); err != nil {
+			return err
+		}
+	}
+
+	if len(bl.segments) == 0 {
+		if _, err := bl.createSegment(1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadSegment reads the records in the specified segment and adds them to
+// the index. Only a bad record at the end of the last segment can be
+// recovered from, since it's the result of a torn write and is truncated. A
+// bad record followed by more data is corruption, since truncating it could
+// drop blocks that were written fine.
+func (bl *BlockLog) loadSegment(segIdx int, last bool) error {
+	seg := bl.segments[segIdx]
+
+	info, err := seg.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(seg.file, 0, info.Size()))
+
+	var offset int64
+	for offset < info.Size() {
+		data, err := readRecord(r)
+		if err != nil {
+			if !last {
+				return fmt.Errorf("segment %s: offset %d: %w", seg.file.Name(), offset, err)
+			}
+
+			torn, terr := isTornTail(seg.file, offset, info.Size())
+			if terr != nil {
+				return terr
+			}
+			if !torn {
+				return fmt.Errorf("segment %s: offset %d: %w", seg.file.Name(), offset, err)
+			}
+
+			if err := seg.file.Truncate(offset); err != nil {
+				return err
+			}
+			if err := seg.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+
+		bl.index = append(bl.index, location{
+			segment: segIdx,
+			offset:  offset,
+			length:  uint32(len(data)),
+		})
+		offset += recordHeaderSize + int64(len(data))
+	}
+
+	seg.size = offset
+
+	return nil
+}
+
+// createSegment starts a new segment file for blocks starting with the
+// specified block number.
+func (bl *BlockLog) createSegment(first uint64) (*segment, error) {
+	name := filepath.Join(bl.dbPath, fmt.Sprintf("%020d%s", first, segmentExt))
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	seg := segment{
+		first: first,
+		file:  f,
+	}
+	bl.segments = append(bl.segments, &seg)
+
+	return &seg, nil
+}
+
+// close closes all the segment files.
+func (bl *BlockLog) close() error {
+	var firstErr error
+	for _, seg := range bl.segments {
+		if err := seg.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	bl.segments = nil
+	bl.index = nil
+
+	return firstErr
+}
+
+// readRecord reads a single record and validates its checksum.
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("reading record header: %w", err)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+
+	if length == 0 || length > maxSegmentSize {
+		return nil, fmt.Errorf("record length %d is invalid", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("reading record data: %w", err)
+	}
+
+	if crc32.Checksum(data, crcTable) != checksum {
+		return nil, errors.New("record checksum does not match")
+	}
+
+	return data, nil
+}
+
+// isTornTail reports whether the bad record at the specified offset is the
+// last thing written to the segment. That is the case when the record reaches
+// the end of the segment or everything after it is zeros, which a crash can
+// leave behind when the size of the file grew before its data was written.
+func isTornTail(f *os.File, offset int64, size int64) (bool, error) {
+	rest, err := io.ReadAll(io.NewSectionReader(f, offset, size-offset))
+	if err != nil {
+		return false, err
+	}
+
+	if len(rest) < recordHeaderSize {
+		return true, nil
+	}
+
+	length := int64(binary.BigEndian.Uint32(rest[0:4]))
+	if recordHeaderSize+length >= int64(len(rest)) {
+		return true, nil
+	}
+
+	for _, b := range rest {
+		if b != 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// =============================================================================
+
+// blockLogIterator represents the iteration implementation for walking
+// through and reading blocks in the log. This implements the database
+// Iterator interface.
+type blockLogIterator struct {
+	storage *BlockLog // Access to the storage API.
+	current uint64    // Current block number being iterated over.
+	eoc     bool      // Represents the iterator is at the end of the chain.
+}
+
+// Next retrieves the next block from the log.
+func (bi *blockLogIterator) Next() (database.BlockData, error) {
+	if bi.eoc {
+		return database.BlockData{}, errors.New("end of chain")
+	}
+
+	bi.current++
+	blockData, err := bi.storage.GetBlock(bi.current)
+	if errors.Is(err, errBlockNotExist) {
+		bi.eoc = true
+	}
+
+	return blockData, err
+}
+
+// Done returns the end of chain value.
+func (bi *blockLogIterator) Done() bool {
+	return bi.eoc
+}
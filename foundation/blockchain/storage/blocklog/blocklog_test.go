@@ -0,0 +1,146 @@
+package blocklog_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/blocklog"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+)
+
+// segmentName is the name of the first segment file of the log.
+const segmentName = "00000000000000000001.seg"
+
+// newBlockData constructs the block data for the specified block number.
+func newBlockData(num uint64) database.BlockData {
+	return database.BlockData{
+		Header: database.BlockHeader{Number: num, MiningReward: 700},
+		Trans:  []database.BlockTx{},
+	}
+}
+
+// count returns the number of blocks in the log.
+func count(t *testing.T, bl *blocklog.BlockLog) uint64 {
+	var n uint64
+	iter := bl.ForEach()
+	for blockData, err := iter.Next(); !iter.Done(); blockData, err = iter.Next() {
+		if err != nil {
+			t.Fatalf("reading block %d: %v", n+1, err)
+		}
+		if blockData.Header.Number != n+1 {
+			t.Fatalf("expected block %d, got %d", n+1, blockData.Header.Number)
+		}
+		n++
+	}
+
+	return n
+}
+
+// =============================================================================
+
+func Test_Recover(t *testing.T) {
+	table := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		blocks  uint64
+		wantErr bool
+	}{
+		{
+			name:    "intact",
+			corrupt: func(data []byte) []byte { return data },
+			blocks:  3,
+		},
+		{
+			name:    "torn header",
+			corrupt: func(data []byte) []byte { return append(data, 0, 0, 1) },
+			blocks:  3,
+		},
+		{
+			name:    "torn data",
+			corrupt: func(data []byte) []byte { return data[:len(data)-5] },
+			blocks:  2,
+		},
+		{
+			name: "bad checksum at end",
+			corrupt: func(data []byte) []byte {
+				data[len(data)-1] ^= 0xff
+				return data
+			},
+			blocks: 2,
+		},
+		{
+			name:    "zero filled tail",
+			corrupt: func(data []byte) []byte { return append(data, make([]byte, 100)...) },
+			blocks:  3,
+		},
+		{
+			name: "bad checksum mid file",
+			corrupt: func(data []byte) []byte {
+				data[10] ^= 0xff
+				return data
+			},
+			wantErr: true,
+		},
+		{
+			name:    "data after zeros",
+			corrupt: func(data []byte) []byte { return append(append(data, make([]byte, 100)...), 1) },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := t.TempDir()
+
+			cdc, err := codec.New("json")
+			if err != nil {
+				t.Fatalf("constructing codec: %v", err)
+			}
+
+			bl, err := blocklog.New(dbPath, cdc)
+			if err != nil {
+				t.Fatalf("constructing block log: %v", err)
+			}
+			for num := uint64(1); num <= 3; num++ {
+				if err := bl.Write(newBlockData(num)); err != nil {
+					t.Fatalf("writing block %d: %v", num, err)
+				}
+			}
+			if err := bl.Close(); err != nil {
+				t.Fatalf("closing block log: %v", err)
+			}
+
+			path := filepath.Join(dbPath, segmentName)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading segment: %v", err)
+			}
+			if err := os.WriteFile(path, tt.corrupt(data), 0600); err != nil {
+				t.Fatalf("writing segment: %v", err)
+			}
+
+			bl, err = blocklog.New(dbPath, cdc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			defer bl.Close()
+
+			if got := count(t, bl); got != tt.blocks {
+				t.Fatalf("expected %d blocks, got %d", tt.blocks, got)
+			}
+
+			// The log has to take the next block after the truncation.
+			if err := bl.Write(newBlockData(tt.blocks + 1)); err != nil {
+				t.Fatalf("writing block after recovery: %v", err)
+			}
+			if got := count(t, bl); got != tt.blocks+1 {
+				t.Errorf("expected %d blocks after write, got %d", tt.blocks+1, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+// Package codec provides support for encoding blocks for storage. JSON is
+// human readable for debugging and RLP is a compact binary encoding.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Codec represents the behavior required to encode and decode blocks for
+// a storage implementation.
+type Codec interface {
+	Name() string
+	Encode(blockData database.BlockData) ([]byte, error)
+	Decode(data []byte) (database.BlockData, error)
+}
+
+// New constructs the codec with the specified name.
+func New(name string) (Codec, error) {
+	switch name {
+	case "json":
+		return JSON{}, nil
+	case "rlp":
+		return RLP{}, nil
+	}
+
+	return nil, fmt.Errorf("codec %q does not exist", name)
+}
+
+// =============================================================================
+
+// JSON encodes blocks as indented JSON.
+type JSON struct{}
+
+// Name returns the name of the codec.
+func (JSON) Name() string {
+	return "json"
+}
+
+// Encode marshals the block in a more human readable format.
+func (JSON) Encode(blockData database.BlockData) ([]byte, error) {
+	return json.MarshalIndent(blockData, "", "  ")
+}
+
+// Decode unmarshals the block.
+func (JSON) Decode(data []byte) (database.BlockData, error) {
+	var blockData database.BlockData
+	if err := json.Unmarshal(data, &blockData); err != nil {
+		return database.BlockData{}, err
+	}
+
+	return blockData, nil
+}
+
+// =============================================================================
+
+// RLP encodes blocks using Ethereum's recursive length prefix encoding. The
+// block hash isn't stored since it's calculated from the header.
+type RLP struct{}
+
+// Name returns the name of the codec.
+func (RLP) Name() string {
+	return "rlp"
+}
+
+// Encode marshals the block into RLP.
+func (RLP) Encode(blockData database.BlockData) ([]byte, error) {
+	block := rlpBlock{
+		Header: blockData.Header,
+		V:      blockData.V,
+		R:      blockData.R,
+		S:      blockData.S,
+		Trans:  make([]rlpTx, len(blockData.Trans)),
+	}
+
+	for i, tx := range blockData.Trans {
+		block.Trans[i] = rlpTx{
+			ChainID:   tx.ChainID,
+			Nonce:     tx.Nonce,
+			FromID:    tx.FromID,
+			ToID:      tx.ToID,
+			Value:     tx.Value,
+			Tip:       tx.Tip,
+			Data:      tx.Data,
+			NilData:   tx.Data == nil,
+			V:         tx.V,
+			R:         tx.R,
+			S:         tx.S,
+			TimeStamp: tx.TimeStamp,
+			GasPrice:  tx.GasPrice,
+			GasUnits:  tx.GasUnits,
+		}
+	}
+
+	return rlp.EncodeToBytes(block)
+}
+
+// Decode unmarshals the block from RLP.
+func (RLP) Decode(data []byte) (database.BlockData, error) {
+	var block rlpBlock
+	if err := rlp.DecodeBytes(data, &block); err != nil {
+		return database.BlockData{}, err
+	}
+
+	blockData := database.BlockData{
+		Hash:   database.Block{Header: block.Header}.Hash(),
+		Header: block.Header,
+		Trans:  make([]database.BlockTx, len(block.Trans)),
+	}
+	if !isNilSignature(block.R, block.S) {
+		blockData.V, blockData.R, blockData.S = block.V, block.R, block.S
+	}
+
+	for i, tx := range block.Trans {
+		// Transactions are hashed as JSON, so a missing data field or
+		// signature has to stay nil to produce the same hash.
+		if tx.NilData {
+			tx.Data = nil
+		}
+		if isNilSignature(tx.R, tx.S) {
+			tx.V, tx.R, tx.S = nil, nil, nil
+		}
+
+		blockData.Trans[i] = database.BlockTx{
+			SignedTx: database.SignedTx{
+				Tx: database.Tx{
+					ChainID: tx.ChainID,
+					Nonce:   tx.Nonce,
+					FromID:  tx.FromID,
+					ToID:    tx.ToID,
+					Value:   tx.Value,
+					Tip:     tx.Tip,
+					Data:    tx.Data,
+				},
+				V: tx.V,
+				R: tx.R,
+				S: tx.S,
+			},
+			TimeStamp: tx.TimeStamp,
+			GasPrice:  tx.GasPrice,
+			GasUnits:  tx.GasUnits,
+		}
+	}
+
+	return blockData, nil
+}
+
+// isNilSignature reports whether the signature was nil when it was encoded.
+// RLP encodes a nil big integer as 0 and a valid signature never has an R or
+// S of 0.
+func isNilSignature(r *big.Int, s *big.Int) bool {
+	return r == nil || s == nil || (r.Sign() == 0 && s.Sign() == 0)
+}
+
+// rlpBlock is the layout of a block in RLP.
+type rlpBlock struct {
+	Header database.BlockHeader
+	V      *big.Int `rlp:"nil"`
+	R      *big.Int `rlp:"nil"`
+	S      *big.Int `rlp:"nil"`
+	Trans  []rlpTx
+}
+
+// rlpTx is the layout of a block transaction in RLP.
+type rlpTx struct {
+	ChainID   uint16
+	Nonce     uint64
+	FromID    database.AccountID
+	ToID      database.AccountID
+	Value     uint64
+	Tip       uint64
+	Data      []byte
+	NilData   bool
+	V         *big.Int `rlp:"nil"`
+	R         *big.Int `rlp:"nil"`
+	S         *big.Int `rlp:"nil"`
+	TimeStamp uint64
+	GasPrice  uint64
+	GasUnits  uint64
+}
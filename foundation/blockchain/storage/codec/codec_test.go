@@ -0,0 +1,149 @@
+package codec_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func Test_New(t *testing.T) {
+	table := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "json"},
+		{name: "rlp"},
+		{name: "xml", wantErr: true},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			cdc, err := codec.New(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if err == nil && cdc.Name() != tt.name {
+				t.Errorf("expected name %q, got %q", tt.name, cdc.Name())
+			}
+		})
+	}
+}
+
+func Test_RoundTrip(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	from := database.PublicKeyToAccountID(key.PublicKey)
+	to := database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+	newTx := func(nonce uint64, data []byte) database.BlockTx {
+		tx, err := database.NewTx(1, nonce, from, to, 100, 5, data)
+		if err != nil {
+			t.Fatalf("constructing tx: %v", err)
+		}
+		signedTx, err := tx.Sign(key)
+		if err != nil {
+			t.Fatalf("signing tx: %v", err)
+		}
+		return database.NewBlockTx(signedTx, 15, 1)
+	}
+
+	header := database.BlockHeader{
+		Number:        7,
+		PrevBlockHash: "0x00c8e052f9b978ccb82e9f7f3d71bda73a5cbce22c6f4971e35b57045b034127",
+		TimeStamp:     1792171278722,
+		BeneficiaryID: from,
+		Difficulty:    6,
+		MiningReward:  700,
+		StateRoot:     "0x187c4fd4c30c3ae694644dda31978228a9e6326f82384105093e11cb5a0d28a9",
+		TransRoot:     "0x1baa751f8f1a41dc2aaff5ff0de30b24ccddd086b1dc0509a65cbb1ee81bad9c",
+		Nonce:         1902713345022524602,
+	}
+	hash := database.Block{Header: header}.Hash()
+
+	table := []struct {
+		name      string
+		blockData database.BlockData
+	}{
+		{
+			name:      "unsigned no transactions",
+			blockData: database.BlockData{Hash: hash, Header: header, Trans: []database.BlockTx{}},
+		},
+		{
+			name: "signed with transactions",
+			blockData: database.BlockData{
+				Hash:   hash,
+				Header: header,
+				V:      big.NewInt(29),
+				R:      new(big.Int).Lsh(big.NewInt(1), 255),
+				S:      big.NewInt(12345),
+				Trans:  []database.BlockTx{newTx(1, nil), newTx(2, []byte{}), newTx(3, []byte("data"))},
+			},
+		},
+	}
+
+	for _, name := range []string{"json", "rlp"} {
+		cdc, err := codec.New(name)
+		if err != nil {
+			t.Fatalf("constructing codec %s: %v", name, err)
+		}
+
+		for _, tt := range table {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				data, err := cdc.Encode(tt.blockData)
+				if err != nil {
+					t.Fatalf("encoding: %v", err)
+				}
+
+				got, err := cdc.Decode(data)
+				if err != nil {
+					t.Fatalf("decoding: %v", err)
+				}
+
+				if got.Hash != tt.blockData.Hash {
+					t.Errorf("expected hash %s, got %s", tt.blockData.Hash, got.Hash)
+				}
+				if got.Header != tt.blockData.Header {
+					t.Errorf("expected header %+v, got %+v", tt.blockData.Header, got.Header)
+				}
+				if !equalInt(got.V, tt.blockData.V) || !equalInt(got.R, tt.blockData.R) || !equalInt(got.S, tt.blockData.S) {
+					t.Errorf("expected signature %v %v %v, got %v %v %v", tt.blockData.V, tt.blockData.R, tt.blockData.S, got.V, got.R, got.S)
+				}
+
+				if len(got.Trans) != len(tt.blockData.Trans) {
+					t.Fatalf("expected %d transactions, got %d", len(tt.blockData.Trans), len(got.Trans))
+				}
+				for i := range got.Trans {
+
+					// The transaction hash covers every field, including
+					// whether the data is nil.
+					exp, err := tt.blockData.Trans[i].Hash()
+					if err != nil {
+						t.Fatalf("hashing tx %d: %v", i, err)
+					}
+					hash, err := got.Trans[i].Hash()
+					if err != nil {
+						t.Fatalf("hashing decoded tx %d: %v", i, err)
+					}
+					if !bytes.Equal(hash, exp) {
+						t.Errorf("tx %d: expected hash %x, got %x", i, exp, hash)
+					}
+				}
+			})
+		}
+	}
+}
+
+// equalInt compares two big integers where nil only equals nil.
+func equalInt(a *big.Int, b *big.Int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.Cmp(b) == 0
+}
@@ -3,31 +3,58 @@
 package disk
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
 )
 
+// ErrChecksum is returned when the contents of a block on disk don't match
+// the checksum written with it.
+var ErrChecksum = errors.New("block does not match its checksum")
+
+// tempPattern is the pattern for the temporary files blocks are written to
+// before they are renamed into place.
+const tempPattern = ".tmp-*"
+
 // Disk represents the serialization implementation for reading and storing
 // blocks in their own separate files on disk. This implements the database.Storage
 // interface.
 type Disk struct {
 	dbPath string
+	codec  codec.Codec
 }
 
-// New constructs an Disk value for use.
-func New(dbPath string) (*Disk, error) {
+// New constructs an Disk value for use. The codec determines how the blocks
+// are encoded and the extension of the block files. Temporary files left
+// behind by a crash in the middle of a write are removed.
+func New(dbPath string, codec codec.Codec) (*Disk, error) {
 	if err := os.MkdirAll(dbPath, 0755); err != nil {
 		return nil, err
 	}
 
-	return &Disk{dbPath: dbPath}, nil
+	names, err := filepath.Glob(filepath.Join(dbPath, tempPattern))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Disk{dbPath: dbPath, codec: codec}, nil
 }
 
 // Close in this implementation has nothing to do since a new file is
@@ -37,47 +64,53 @@ func (d *Disk) Close() error {
 }
 
 // Write takes the specified database blocks and stores it on disk in a
-// file labeled with the block number.
+// file labeled with the block number. A checksum of the contents is stored
+// in a file next to it. Each file is written atomically so a crash never
+// leaves a partially written block behind.
 func (d *Disk) Write(blockData database.BlockData) error {
 
-	// Marshal the block for writing to disk in a more human readable format.
-	data, err := json.MarshalIndent(blockData, "", "  ")
+	// Encode the block for writing to disk.
+	data, err := d.codec.Encode(blockData)
 	if err != nil {
 		return err
 	}
 
-	// Create a new file for this block and name it based on the block number.
-	f, err := os.OpenFile(d.getPath(blockData.Header.Number), os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
+	// The checksum is written first. A checksum without a block is never
+	// read and is replaced when the block is written again.
+	sum := sha256.Sum256(data)
+	if err := d.writeFile(d.getSumPath(blockData.Header.Number), []byte(hex.EncodeToString(sum[:]))); err != nil {
 		return err
 	}
-	defer f.Close()
 
 	// Write the new block to disk.
-	if _, err := f.Write(data); err != nil {
+	if err := d.writeFile(d.getPath(blockData.Header.Number), data); err != nil {
 		return err
 	}
 
-	return nil
+	return d.syncDir()
 }
 
 // GetBlock searches the blockchain on disk to locate and return the
 // contents of the specified block by number.
 func (d *Disk) GetBlock(num uint64) (database.BlockData, error) {
 
-	// Open the block file for the specified number.
-	f, err := os.OpenFile(d.getPath(num), os.O_RDONLY, 0600)
+	// Read the block file for the specified number.
+	data, err := os.ReadFile(d.getPath(num))
 	if err != nil {
 		return database.BlockData{}, err
 	}
-	defer f.Close()
 
-	// Decode the contents of the block.
-	var blockData database.BlockData
-	if err := json.NewDecoder(f).Decode(&blockData); err != nil {
+	// Make sure the contents weren't corrupted on disk.
+	if err := d.checkSum(num, data); err != nil {
 		return database.BlockData{}, err
 	}
 
+	// Decode the contents of the block.
+	blockData, err := d.codec.Decode(data)
+	if err != nil {
+		return database.BlockData{}, fmt.Errorf("block %d: %w", num, err)
+	}
+
 	// Return the block as a database block.
 	return blockData, nil
 }
@@ -88,6 +121,12 @@ func (d *Disk) ForEach() database.Iterator {
 	return &diskIterator{storage: d}
 }
 
+// ForEachFrom returns an iterator to walk through the blocks starting
+// with the specified block number.
+func (d *Disk) ForEachFrom(num uint64) database.Iterator {
+	return &diskIterator{storage: d, current: num - 1}
+}
+
 // Reset will clear out the blockchain on disk.
 func (d *Disk) Reset() error {
 	if err := os.RemoveAll(d.dbPath); err != nil {
@@ -97,10 +136,227 @@ func (d *Disk) Reset() error {
 	return os.MkdirAll(d.dbPath, 0755)
 }
 
+// Truncate removes all the blocks after the specified block number.
+func (d *Disk) Truncate(num uint64) error {
+	nums, err := d.blockNumbers()
+	if err != nil {
+		return err
+	}
+
+	for _, blockNum := range nums {
+		if blockNum <= num {
+			continue
+		}
+
+		if err := os.Remove(d.getPath(blockNum)); err != nil {
+			return err
+		}
+		if err := os.Remove(d.getSumPath(blockNum)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return d.syncDir()
+}
+
+// Remove deletes the specified block from disk. An error that matches
+// fs.ErrNotExist is returned if the block isn't on disk.
+func (d *Disk) Remove(num uint64) error {
+	if err := os.Remove(d.getPath(num)); err != nil {
+		return err
+	}
+	if err := os.Remove(d.getSumPath(num)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// Corruption describes a block on disk that failed verification.
+type Corruption struct {
+	Number uint64
+	Err    error
+}
+
+// Verify checks every block on disk against its checksum, the block number
+// it's stored under and the hash of the block before it. The blocks that
+// fail are returned in block number order.
+func (d *Disk) Verify() ([]Corruption, error) {
+	nums, err := d.blockNumbers()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nums) == 0 {
+		return nil, nil
+	}
+
+	var corrupt []Corruption
+	prevHash := signature.ZeroHash
+
+	for num := uint64(1); num <= nums[len(nums)-1]; num++ {
+		blockData, err := d.GetBlock(num)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				err = fmt.Errorf("block %d: missing", num)
+			}
+			corrupt = append(corrupt, Corruption{Number: num, Err: err})
+			prevHash = ""
+			continue
+		}
+
+		if err := verifyBlock(num, blockData, prevHash); err != nil {
+			corrupt = append(corrupt, Corruption{Number: num, Err: fmt.Errorf("block %d: %w", num, err)})
+		}
+		prevHash = blockData.Hash
+	}
+
+	return corrupt, nil
+}
+
+// Repair truncates the chain back to the last good block before the first
+// corrupt block. The number of the latest block left on disk is returned.
+func (d *Disk) Repair() (uint64, error) {
+	corrupt, err := d.Verify()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(corrupt) == 0 {
+		nums, err := d.blockNumbers()
+		if err != nil || len(nums) == 0 {
+			return 0, err
+		}
+		return nums[len(nums)-1], nil
+	}
+
+	lastGood := corrupt[0].Number - 1
+	if err := d.Truncate(lastGood); err != nil {
+		return 0, err
+	}
+
+	return lastGood, nil
+}
+
+// verifyBlock checks the block is stored under its own number, its hash
+// matches its header and it links to the previous block. An empty previous
+// hash means the previous block is corrupt and the link can't be checked.
+func verifyBlock(num uint64, blockData database.BlockData, prevHash string) error {
+	if blockData.Header.Number != num {
+		return fmt.Errorf("block header has number %d", blockData.Header.Number)
+	}
+
+	block, err := database.ToBlock(blockData)
+	if err != nil {
+		return err
+	}
+
+	if block.Hash() != blockData.Hash {
+		return fmt.Errorf("block hash doesn't match header, got %s, exp %s", blockData.Hash, block.Hash())
+	}
+
+	if prevHash != "" && blockData.Header.PrevBlockHash != prevHash {
+		return fmt.Errorf("parent block hash doesn't match previous block, got %s, exp %s", blockData.Header.PrevBlockHash, prevHash)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// writeFile atomically replaces the named file with the data by writing to a
+// temporary file, syncing it to disk and renaming it into place.
+func (d *Disk) writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(d.dbPath, tempPattern)
+	if err != nil {
+		return err
+	}
+
+	if err := func() error {
+		defer f.Close()
+
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		return f.Sync()
+	}(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// syncDir makes sure the renamed files are recorded in the directory.
+func (d *Disk) syncDir() error {
+	dir, err := os.Open(d.dbPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// checkSum compares the block contents with the checksum stored next to it.
+// Blocks written before checksums were stored don't have one.
+func (d *Disk) checkSum(num uint64, data []byte) error {
+	want, err := os.ReadFile(d.getSumPath(num))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != strings.TrimSpace(string(want)) {
+		return fmt.Errorf("block %d: %w", num, ErrChecksum)
+	}
+
+	return nil
+}
+
+// blockNumbers returns the numbers of the blocks on disk in order.
+func (d *Disk) blockNumbers() ([]uint64, error) {
+	ext := "." + d.codec.Name()
+
+	names, err := filepath.Glob(filepath.Join(d.dbPath, "*"+ext))
+	if err != nil {
+		return nil, err
+	}
+
+	nums := make([]uint64, 0, len(names))
+	for _, name := range names {
+		num, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		nums = append(nums, num)
+	}
+
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	return nums, nil
+}
+
 // getPath forms the path to the specified block.
 func (d *Disk) getPath(blockNum uint64) string {
 	name := strconv.FormatUint(blockNum, 10)
-	return path.Join(d.dbPath, fmt.Sprintf("%s.json", name))
+	return path.Join(d.dbPath, fmt.Sprintf("%s.%s", name, d.codec.Name()))
+}
+
+// getSumPath forms the path to the checksum of the specified block.
+func (d *Disk) getSumPath(blockNum uint64) string {
+	name := strconv.FormatUint(blockNum, 10)
+	return path.Join(d.dbPath, fmt.Sprintf("%s.%s.sha256", name, d.codec.Name()))
 }
 
 // =============================================================================
@@ -0,0 +1,190 @@
+package disk_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/disk"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// newChain constructs the block data for a chain with the specified number
+// of linked blocks, each holding a single transaction.
+func newChain(t *testing.T, n int) []database.BlockData {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	from := database.PublicKeyToAccountID(key.PublicKey)
+	to := database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+	chain := make([]database.BlockData, n)
+
+	prevHash := signature.ZeroHash
+	for i := range chain {
+		tx, err := database.NewTx(1, uint64(i+1), from, to, 100, 1, nil)
+		if err != nil {
+			t.Fatalf("constructing tx: %v", err)
+		}
+		signedTx, err := tx.Sign(key)
+		if err != nil {
+			t.Fatalf("signing tx: %v", err)
+		}
+
+		header := database.BlockHeader{
+			Number:        uint64(i + 1),
+			PrevBlockHash: prevHash,
+			TimeStamp:     uint64(1000 + i),
+			MiningReward:  700,
+		}
+		chain[i] = database.BlockData{
+			Hash:   database.Block{Header: header}.Hash(),
+			Header: header,
+			Trans:  []database.BlockTx{database.NewBlockTx(signedTx, 15, 1)},
+		}
+		prevHash = chain[i].Hash
+	}
+
+	return chain
+}
+
+// =============================================================================
+
+func Test_VerifyRepair(t *testing.T) {
+	table := []struct {
+		name    string
+		corrupt func(t *testing.T, d *disk.Disk, dbPath string, chain []database.BlockData)
+		exp     []uint64 // Numbers of the corrupt blocks.
+		latest  uint64   // Latest block after the repair.
+	}{
+		{
+			name:    "intact",
+			corrupt: func(t *testing.T, d *disk.Disk, dbPath string, chain []database.BlockData) {},
+			latest:  4,
+		},
+		{
+			name: "checksum mismatch",
+			corrupt: func(t *testing.T, d *disk.Disk, dbPath string, chain []database.BlockData) {
+				path := filepath.Join(dbPath, "3.json")
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("reading block: %v", err)
+				}
+				data[len(data)/2] ^= 0x01
+				if err := os.WriteFile(path, data, 0600); err != nil {
+					t.Fatalf("writing block: %v", err)
+				}
+			},
+			exp:    []uint64{3},
+			latest: 2,
+		},
+		{
+			name: "missing block",
+			corrupt: func(t *testing.T, d *disk.Disk, dbPath string, chain []database.BlockData) {
+				if err := d.Remove(2); err != nil {
+					t.Fatalf("removing block: %v", err)
+				}
+			},
+			exp:    []uint64{2},
+			latest: 1,
+		},
+		{
+			name: "stored under the wrong number",
+			corrupt: func(t *testing.T, d *disk.Disk, dbPath string, chain []database.BlockData) {
+				for _, ext := range []string{".json", ".json.sha256"} {
+					data, err := os.ReadFile(filepath.Join(dbPath, "4"+ext))
+					if err != nil {
+						t.Fatalf("reading block: %v", err)
+					}
+					if err := os.WriteFile(filepath.Join(dbPath, "3"+ext), data, 0600); err != nil {
+						t.Fatalf("writing block: %v", err)
+					}
+				}
+			},
+			exp:    []uint64{3, 4},
+			latest: 2,
+		},
+		{
+			name: "broken link",
+			corrupt: func(t *testing.T, d *disk.Disk, dbPath string, chain []database.BlockData) {
+				blockData := chain[2]
+				blockData.Header.PrevBlockHash = chain[0].Hash
+				blockData.Hash = database.Block{Header: blockData.Header}.Hash()
+				if err := d.Write(blockData); err != nil {
+					t.Fatalf("writing block: %v", err)
+				}
+			},
+			exp:    []uint64{3, 4},
+			latest: 2,
+		},
+		{
+			name: "no checksum",
+			corrupt: func(t *testing.T, d *disk.Disk, dbPath string, chain []database.BlockData) {
+				if err := os.Remove(filepath.Join(dbPath, "2.json.sha256")); err != nil {
+					t.Fatalf("removing checksum: %v", err)
+				}
+			},
+			latest: 4,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := t.TempDir()
+
+			cdc, err := codec.New("json")
+			if err != nil {
+				t.Fatalf("constructing codec: %v", err)
+			}
+
+			d, err := disk.New(dbPath, cdc)
+			if err != nil {
+				t.Fatalf("constructing disk: %v", err)
+			}
+
+			chain := newChain(t, 4)
+			for _, blockData := range chain {
+				if err := d.Write(blockData); err != nil {
+					t.Fatalf("writing block %d: %v", blockData.Header.Number, err)
+				}
+			}
+
+			tt.corrupt(t, d, dbPath, chain)
+
+			corrupt, err := d.Verify()
+			if err != nil {
+				t.Fatalf("verifying: %v", err)
+			}
+
+			if len(corrupt) != len(tt.exp) {
+				t.Fatalf("expected corrupt blocks %v, got %v", tt.exp, corrupt)
+			}
+			for i, c := range corrupt {
+				if c.Number != tt.exp[i] {
+					t.Errorf("expected corrupt block %d, got %d: %v", tt.exp[i], c.Number, c.Err)
+				}
+			}
+
+			latest, err := d.Repair()
+			if err != nil {
+				t.Fatalf("repairing: %v", err)
+			}
+			if latest != tt.latest {
+				t.Errorf("expected latest block %d, got %d", tt.latest, latest)
+			}
+
+			// The chain left behind has to verify and end at the latest block.
+			if corrupt, err := d.Verify(); err != nil || len(corrupt) != 0 {
+				t.Errorf("expected no corrupt blocks after repair, got %v, %v", corrupt, err)
+			}
+			if _, err := d.GetBlock(latest + 1); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected block %d to be removed, got %v", latest+1, err)
+			}
+		})
+	}
+}
@@ -49,12 +49,13 @@ func (m *Memory) GetBlock(num uint64) (database.BlockData, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	// Block numbers start at 1 so the block is stored at index num-1.
 	l := uint64(len(m.blocks))
-	if l == 0 || num >= l {
+	if num == 0 || num > l {
 		return database.BlockData{}, errors.New("block does not exist")
 	}
 
-	return m.blocks[num], nil
+	return m.blocks[num-1], nil
 }
 
 // ForEach returns an iterator to walk through all the blocks
@@ -63,6 +64,12 @@ func (m *Memory) ForEach() database.Iterator {
 	return &memoryIterator{storage: m}
 }
 
+// ForEachFrom returns an iterator to walk through the blocks starting
+// with the specified block number.
+func (m *Memory) ForEachFrom(num uint64) database.Iterator {
+	return &memoryIterator{storage: m, current: num - 1}
+}
+
 // Reset will clear out the blockchain on disk.
 func (m *Memory) Reset() error {
 	m.mu.Lock()
@@ -72,6 +79,18 @@ func (m *Memory) Reset() error {
 	return nil
 }
 
+// Truncate removes all the blocks after the specified block number.
+func (m *Memory) Truncate(num uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if num < uint64(len(m.blocks)) {
+		m.blocks = m.blocks[:num]
+	}
+
+	return nil
+}
+
 // =============================================================================
 
 // memoryIterator represents the iteration implementation for walking
@@ -89,13 +108,12 @@ func (mi *memoryIterator) Next() (database.BlockData, error) {
 		return database.BlockData{}, errors.New("end of chain")
 	}
 
+	mi.current++
 	blockData, err := mi.storage.GetBlock(mi.current)
 	if err != nil {
 		mi.eoc = true
 	}
 
-	mi.current++
-
 	return blockData, err
 }
 
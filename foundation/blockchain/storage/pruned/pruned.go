@@ -0,0 +1,208 @@
+// Package pruned implements the ability to read and write blocks to disk
+// keeping the block headers separate from the transactions, so the
+// transactions of old blocks can be removed to save space.
+package pruned
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/disk"
+)
+
+// Pruned represents the serialization implementation for reading and storing
+// blocks with the headers and the transactions stored on disk separately.
+// Every header is kept, but only the transactions of the blocks within the
+// configured depth of the latest block are. This implements the
+// database.Storage and database.HeaderStorage interfaces.
+type Pruned struct {
+	mu      sync.Mutex
+	headers *disk.Disk
+	bodies  *disk.Disk
+	depth   uint64
+	pruned  uint64 // Latest block whose transactions are known to be pruned.
+}
+
+// New constructs a Pruned value for use. The headers and transactions are
+// stored in separate directories under the specified path. The depth is
+// the number of recent blocks whose transactions are never pruned.
+func New(dbPath string, codec codec.Codec, depth uint64) (*Pruned, error) {
+	if depth == 0 {
+		return nil, errors.New("prune depth must be greater than 0")
+	}
+
+	headers, err := disk.New(filepath.Join(dbPath, "headers"), codec)
+	if err != nil {
+		return nil, err
+	}
+
+	bodies, err := disk.New(filepath.Join(dbPath, "bodies"), codec)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Pruned{
+		headers: headers,
+		bodies:  bodies,
+		depth:   depth,
+	}
+
+	return &p, nil
+}
+
+// Close closes the headers and transactions on disk.
+func (p *Pruned) Close() error {
+	if err := p.bodies.Close(); err != nil {
+		return err
+	}
+
+	return p.headers.Close()
+}
+
+// Write takes the specified database block and stores the transactions and
+// then the header on disk. The block only exists once the header is written.
+func (p *Pruned) Write(blockData database.BlockData) error {
+	body := database.BlockData{
+		Header: database.BlockHeader{Number: blockData.Header.Number},
+		Trans:  blockData.Trans,
+	}
+
+	if err := p.bodies.Write(body); err != nil {
+		return err
+	}
+
+	header := blockData
+	header.Trans = nil
+
+	return p.headers.Write(header)
+}
+
+// GetBlock searches the blockchain on disk to locate and return the
+// contents of the specified block by number. If the transactions of the
+// block have been pruned, an error matching database.ErrPruned is returned.
+func (p *Pruned) GetBlock(num uint64) (database.BlockData, error) {
+	blockData, err := p.headers.GetBlock(num)
+	if err != nil {
+		return database.BlockData{}, err
+	}
+
+	body, err := p.bodies.GetBlock(num)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return database.BlockData{}, fmt.Errorf("block %d: %w", num, database.ErrPruned)
+		}
+		return database.BlockData{}, err
+	}
+
+	blockData.Trans = body.Trans
+
+	return blockData, nil
+}
+
+// GetHeader searches the blockchain on disk to locate and return the header
+// of the specified block by number. The block data has no transactions.
+func (p *Pruned) GetHeader(num uint64) (database.BlockData, error) {
+	return p.headers.GetBlock(num)
+}
+
+// ForEach returns an iterator to walk through all the blocks
+// starting with block number 1.
+func (p *Pruned) ForEach() database.Iterator {
+	return &prunedIterator{storage: p}
+}
+
+// ForEachFrom returns an iterator to walk through the blocks starting
+// with the specified block number.
+func (p *Pruned) ForEachFrom(num uint64) database.Iterator {
+	return &prunedIterator{storage: p, current: num - 1}
+}
+
+// ForEachHeader returns an iterator to walk through the headers of all
+// the blocks starting with block number 1.
+func (p *Pruned) ForEachHeader() database.Iterator {
+	return p.headers.ForEach()
+}
+
+// Reset will clear out the blockchain on disk.
+func (p *Pruned) Reset() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.headers.Reset(); err != nil {
+		return err
+	}
+
+	p.pruned = 0
+
+	return p.bodies.Reset()
+}
+
+// Truncate removes all the blocks after the specified block number. The
+// headers are removed first so a crash never leaves a header without its
+// transactions.
+func (p *Pruned) Truncate(num uint64) error {
+	if err := p.headers.Truncate(num); err != nil {
+		return err
+	}
+
+	return p.bodies.Truncate(num)
+}
+
+// Prune removes the transactions of the blocks more than the depth behind
+// the specified block number. The accounts up to the specified block must
+// be covered by a snapshot, since the blocks can no longer be replayed.
+func (p *Pruned) Prune(num uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if num <= p.depth {
+		return nil
+	}
+
+	// The oldest blocks are removed first, so the first call after the node
+	// starts walks over the blocks pruned before without finding them.
+	for blockNum := p.pruned + 1; blockNum <= num-p.depth; blockNum++ {
+		if err := p.bodies.Remove(blockNum); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		p.pruned = blockNum
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// prunedIterator represents the iteration implementation for walking
+// through and reading blocks on disk. This implements the database
+// Iterator interface.
+type prunedIterator struct {
+	storage *Pruned // Access to the storage API.
+	current uint64  // Current block number being iterated over.
+	eoc     bool    // Represents the iterator is at the end of the chain.
+}
+
+// Next retrieves the next block from disk.
+func (pi *prunedIterator) Next() (database.BlockData, error) {
+	if pi.eoc {
+		return database.BlockData{}, errors.New("end of chain")
+	}
+
+	pi.current++
+	blockData, err := pi.storage.GetBlock(pi.current)
+	if errors.Is(err, fs.ErrNotExist) {
+		pi.eoc = true
+	}
+
+	return blockData, err
+}
+
+// Done returns the end of chain value.
+func (pi *prunedIterator) Done() bool {
+	return pi.eoc
+}
@@ -0,0 +1,122 @@
+package pruned_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/codec"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/pruned"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// newBlockData constructs the block data for the specified number of blocks,
+// each holding a single transaction.
+func newBlockData(t *testing.T, n int) []database.BlockData {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	from := database.PublicKeyToAccountID(key.PublicKey)
+	to := database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+	blocks := make([]database.BlockData, n)
+	for i := range blocks {
+		tx, err := database.NewTx(1, uint64(i+1), from, to, 100, 1, nil)
+		if err != nil {
+			t.Fatalf("constructing tx: %v", err)
+		}
+		signedTx, err := tx.Sign(key)
+		if err != nil {
+			t.Fatalf("signing tx: %v", err)
+		}
+
+		blocks[i] = database.BlockData{
+			Header: database.BlockHeader{Number: uint64(i + 1), TimeStamp: uint64(1000 + i)},
+			Trans:  []database.BlockTx{database.NewBlockTx(signedTx, 15, 1)},
+		}
+	}
+
+	return blocks
+}
+
+// =============================================================================
+
+func Test_Prune(t *testing.T) {
+	table := []struct {
+		name   string
+		depth  uint64
+		prune  []uint64 // Block numbers passed to Prune in order.
+		pruned uint64   // Latest block whose transactions are removed.
+	}{
+		{name: "nothing pruned", depth: 3, pruned: 0},
+		{name: "within the depth", depth: 3, prune: []uint64{3}, pruned: 0},
+		{name: "behind the depth", depth: 3, prune: []uint64{6}, pruned: 3},
+		{name: "pruned again", depth: 3, prune: []uint64{4, 6, 8}, pruned: 5},
+		{name: "pruned out of order", depth: 3, prune: []uint64{8, 6}, pruned: 5},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			cdc, err := codec.New("json")
+			if err != nil {
+				t.Fatalf("constructing codec: %v", err)
+			}
+
+			p, err := pruned.New(t.TempDir(), cdc, tt.depth)
+			if err != nil {
+				t.Fatalf("constructing storage: %v", err)
+			}
+			defer p.Close()
+
+			blocks := newBlockData(t, 8)
+			for _, blockData := range blocks {
+				if err := p.Write(blockData); err != nil {
+					t.Fatalf("writing block %d: %v", blockData.Header.Number, err)
+				}
+			}
+
+			for _, num := range tt.prune {
+				if err := p.Prune(num); err != nil {
+					t.Fatalf("pruning %d: %v", num, err)
+				}
+			}
+
+			for _, blockData := range blocks {
+				num := blockData.Header.Number
+
+				// The header is kept for every block.
+				header, err := p.GetHeader(num)
+				if err != nil {
+					t.Fatalf("block %d: getting header: %v", num, err)
+				}
+				if header.Header.TimeStamp != blockData.Header.TimeStamp || len(header.Trans) != 0 {
+					t.Errorf("block %d: expected the header only, got %+v", num, header)
+				}
+
+				got, err := p.GetBlock(num)
+				switch {
+				case num <= tt.pruned:
+					if !errors.Is(err, database.ErrPruned) {
+						t.Errorf("block %d: expected pruned, got %v", num, err)
+					}
+				case err != nil:
+					t.Errorf("block %d: expected the transactions, got %v", num, err)
+				case len(got.Trans) != 1 || got.Header.TimeStamp != blockData.Header.TimeStamp:
+					t.Errorf("block %d: expected the block, got %+v", num, got)
+				}
+			}
+		})
+	}
+}
+
+func Test_New(t *testing.T) {
+	cdc, err := codec.New("json")
+	if err != nil {
+		t.Fatalf("constructing codec: %v", err)
+	}
+
+	if _, err := pruned.New(t.TempDir(), cdc, 0); err == nil {
+		t.Errorf("expected a depth of 0 to be rejected")
+	}
+}
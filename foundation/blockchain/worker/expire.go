@@ -0,0 +1,37 @@
+package worker
+
+import "time"
+
+// CORE NOTE: A transaction can sit in the mempool forever if it's never
+// selected, like a transaction with a low tip or one queued behind a nonce
+// that never arrives. Ethereum nodes drop these transactions after a period
+// of time and so do we. The wallet has to submit the transaction again.
+
+// expireOperations handles removing expired transactions from the mempool.
+func (w *Worker) expireOperations() {
+	w.evHandler("worker: expireOperations: G started")
+	defer w.evHandler("worker: expireOperations: G completed")
+
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !w.isShutdown() {
+				w.runExpireOperation()
+			}
+		case <-w.shut:
+			w.evHandler("worker: expireOperations: received shut signal")
+			return
+		}
+	}
+}
+
+// runExpireOperation removes the transactions that have been in the mempool
+// longer than the TTL.
+func (w *Worker) runExpireOperation() {
+	for _, tx := range w.state.ExpireMempool() {
+		w.evHandler("worker: runExpireOperation: tx[%s]: expired after %v", tx, w.state.MempoolTTL())
+	}
+}
@@ -0,0 +1,66 @@
+package worker
+
+import "github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+
+// CORE NOTE: The p2p network is managed by this goroutine. The node starts
+// with the set of peers provided by configuration. On every peerUpdateInterval
+// each known peer is asked for its status, which includes the peers it knows
+// about. Any peer this node doesn't know about yet is added to the set so
+// blocks and transactions can be shared with it.
+
+// peerOperations handles finding new peers.
+func (w *Worker) peerOperations() {
+	w.evHandler("worker: peerOperations: G started")
+	defer w.evHandler("worker: peerOperations: G completed")
+
+	for {
+		select {
+		case <-w.ticker.C:
+			if !w.isShutdown() {
+				w.runPeersOperation()
+			}
+		case <-w.shut:
+			w.evHandler("worker: peerOperations: received shut signal")
+			return
+		}
+	}
+}
+
+// runPeersOperation updates the peer list.
+func (w *Worker) runPeersOperation() {
+	w.evHandler("worker: runPeersOperation: started")
+	defer w.evHandler("worker: runPeersOperation: completed")
+
+	for _, pr := range w.state.KnownExternalPeers() {
+
+		// Retrieve the status of this peer.
+		peerStatus, err := w.state.NetRequestPeerStatus(pr)
+		if err != nil {
+			w.evHandler("worker: runPeersOperation: NetRequestPeerStatus: %s: ERROR: %s", pr, err)
+			continue
+		}
+
+		// Add peers from this nodes peer list that we are missing.
+		w.addNewPeers(peerStatus.KnownPeers)
+	}
+}
+
+// addNewPeers takes the list of known peers and makes sure they are included
+// in the nodes list of know peers.
+func (w *Worker) addNewPeers(knownPeers []peer.Peer) {
+	w.evHandler("worker: runPeersOperation: addNewPeers: started")
+	defer w.evHandler("worker: runPeersOperation: addNewPeers: completed")
+
+	for _, pr := range knownPeers {
+
+		// Don't add this running node to the known peer list.
+		if pr.Match(w.state.Host()) {
+			continue
+		}
+
+		// Only log when the peer is new.
+		if w.state.AddKnownPeer(pr) {
+			w.evHandler("worker: runPeersOperation: addNewPeers: add peer nodes: adding peer-node %s", pr)
+		}
+	}
+}
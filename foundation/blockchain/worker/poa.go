@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+)
+
+// CORE NOTE: The POA mining operation is managed by this function which runs on
+// it's own goroutine. The node starts a loop that is on a cycleDuration timer.
+// At the beginning of each cycle the schedule is checked to determine if this
+// node's beneficiary is the authority that needs to produce the next block.
+// Authorities take turns in round-robin order based on the block number, so
+// if this node is not scheduled, it waits for the next cycle to check again.
+// If the scheduled authority is not running, the chain won't move forward
+// until it comes back online.
+
+// secondsPerCycle sets the block production operation to happen every
+// 12 seconds.
+const secondsPerCycle = 12
+const cycleDuration = secondsPerCycle * time.Second
+
+// poaOperations handles block production.
+func (w *Worker) poaOperations() {
+	w.evHandler("worker: poaOperations: G started")
+	defer w.evHandler("worker: poaOperations: G completed")
+
+	ticker := time.NewTicker(cycleDuration)
+	defer ticker.Stop()
+
+	// Start this on a cycle boundary so we know all the other nodes
+	// are on the same schedule.
+	resetTicker(ticker)
+
+	for {
+		select {
+		case <-ticker.C:
+			if !w.isShutdown() {
+				w.runPoaOperation()
+			}
+		case <-w.shut:
+			w.evHandler("worker: poaOperations: received shut signal")
+			return
+		}
+
+		// Reset the ticker for the next cycle.
+		resetTicker(ticker)
+	}
+}
+
+// runPoaOperation takes all the transactions from the mempool and writes a
+// new block to the database if this node is the scheduled authority.
+func (w *Worker) runPoaOperation() {
+	w.evHandler("worker: runPoaOperation: MINING: started")
+	defer w.evHandler("worker: runPoaOperation: MINING: completed")
+
+	// If we are not scheduled to produce the next block, return.
+	if !w.state.IsScheduledSigner() {
+		w.evHandler("worker: runPoaOperation: MINING: not the scheduled authority")
+		return
+	}
+
+	// Validate we are allowed to mine and we are not in a resync.
+	if !w.state.IsMiningAllowed() {
+		w.evHandler("worker: runPoaOperation: MINING: turned off")
+		return
+	}
+
+	// Make sure there are transactions in the mempool.
+	length := w.state.MempoolLength()
+	if length == 0 {
+		w.evHandler("worker: runPoaOperation: MINING: no transactions to mine: Txs[%d]", length)
+		return
+	}
+
+	// Drain the cancel mining channel before starting.
+	select {
+	case <-w.cancelMining:
+		w.evHandler("worker: runPoaOperation: MINING: drained cancel channel")
+	default:
+	}
+
+	// The block must be produced within this cycle.
+	ctx, cancel := context.WithTimeout(context.Background(), cycleDuration)
+	defer cancel()
+
+	block, err := w.state.MineNewBlock(ctx)
+	if err != nil {
+		switch {
+		case errors.Is(err, state.ErrNoTransactions):
+			w.evHandler("worker: runPoaOperation: MINING: WARNING: no transactions in mempool")
+		case errors.Is(err, state.ErrNotScheduled):
+			w.evHandler("worker: runPoaOperation: MINING: WARNING: not the scheduled authority")
+		case ctx.Err() != nil:
+			w.evHandler("worker: runPoaOperation: MINING: CANCEL: complete")
+		default:
+			w.evHandler("worker: runPoaOperation: MINING: ERROR: %s", err)
+		}
+		return
+	}
+
+	// We produced a block. Send the new block to the network.
+	w.SignalShareBlock(block)
+}
+
+// resetTicker ensures that the next tick occurs on the next cycle boundary.
+func resetTicker(ticker *time.Ticker) {
+	nextTick := time.Now().Add(cycleDuration).Truncate(cycleDuration)
+	ticker.Reset(time.Until(nextTick))
+}
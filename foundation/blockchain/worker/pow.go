@@ -98,7 +98,7 @@ func (w *Worker) runPowOperation() {
 		}()
 
 		t := time.Now()
-		_, err := w.state.MineNewBlock(ctx)
+		block, err := w.state.MineNewBlock(ctx)
 		duration := time.Since(t)
 
 		w.evHandler("worker: runMiningOperation: MINING: mining duration[%v]", duration)
@@ -114,6 +114,9 @@ func (w *Worker) runPowOperation() {
 			}
 			return
 		}
+
+		// WOW, we mined a block. Send the new block to the network.
+		w.SignalShareBlock(block)
 	}()
 
 	// Wait for both G's to terminate.
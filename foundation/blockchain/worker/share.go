@@ -0,0 +1,63 @@
+package worker
+
+import "github.com/ardanlabs/blockchain/foundation/blockchain/database"
+
+// CORE NOTE: Blocks mined by this node need to be proposed to all the known
+// peers so they can validate them and stop mining the same block number.
+// New transactions accepted into the mempool are shared the same way so every
+// miner has the chance to include them. Sending happens on separate goroutines
+// so mining and the API are not held up waiting on slow or unavailable peers.
+
+// shareBlockOperations handles sending newly mined blocks to peers.
+func (w *Worker) shareBlockOperations() {
+	w.evHandler("worker: shareBlockOperations: G started")
+	defer w.evHandler("worker: shareBlockOperations: G completed")
+
+	for {
+		select {
+		case block := <-w.blockSharing:
+			if !w.isShutdown() {
+				w.runShareBlockOperation(block)
+			}
+		case <-w.shut:
+			w.evHandler("worker: shareBlockOperations: received shut signal")
+			return
+		}
+	}
+}
+
+// runShareBlockOperation sends the specified block to all the known peers.
+func (w *Worker) runShareBlockOperation(block database.Block) {
+	w.evHandler("worker: runShareBlockOperation: started: blk[%d]", block.Header.Number)
+	defer w.evHandler("worker: runShareBlockOperation: completed: blk[%d]", block.Header.Number)
+
+	w.state.NetSendBlockToPeers(block)
+}
+
+// =============================================================================
+
+// shareTxOperations handles sharing new transactions with peers.
+func (w *Worker) shareTxOperations() {
+	w.evHandler("worker: shareTxOperations: G started")
+	defer w.evHandler("worker: shareTxOperations: G completed")
+
+	for {
+		select {
+		case tx := <-w.txSharing:
+			if !w.isShutdown() {
+				w.runShareTxOperation(tx)
+			}
+		case <-w.shut:
+			w.evHandler("worker: shareTxOperations: received shut signal")
+			return
+		}
+	}
+}
+
+// runShareTxOperation sends the specified transaction to all the known peers.
+func (w *Worker) runShareTxOperation(tx database.BlockTx) {
+	w.evHandler("worker: runShareTxOperation: started: tx[%s]", tx)
+	defer w.evHandler("worker: runShareTxOperation: completed: tx[%s]", tx)
+
+	w.state.NetSendTxToPeers(tx)
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
 )
 
@@ -13,15 +14,33 @@ import (
 // and updating the blockchain on disk with missing blocks.
 const peerUpdateInterval = time.Second * 10
 
+// expireInterval represents the interval of checking the mempool for
+// transactions that have been held longer than the TTL.
+const expireInterval = time.Second * 10
+
+// maxBlockShareRequests represents the max number of pending block share
+// requests that can be outstanding before share requests are dropped.
+const maxBlockShareRequests = 10
+
+// maxTxShareRequests represents the max number of pending tx network share
+// requests that can be outstanding before share requests are dropped. To keep
+// this simple, a buffered channel of this arbitrary number is being used. If
+// the channel does become full, requests for new transactions to be shared
+// will not be accepted.
+const maxTxShareRequests = 100
+
 // =============================================================================
 
-// Worker manages the POW workflows for the blockchain.
+// Worker manages the POW and POA workflows for the blockchain.
 type Worker struct {
 	state        *state.State
 	wg           sync.WaitGroup
+	ticker       *time.Ticker
 	shut         chan struct{}
 	startMining  chan bool
 	cancelMining chan bool
+	blockSharing chan database.Block
+	txSharing    chan database.BlockTx
 	evHandler    state.EventHandler
 }
 
@@ -30,9 +49,12 @@ type Worker struct {
 func Run(st *state.State, evHandler state.EventHandler) {
 	w := Worker{
 		state:        st,
+		ticker:       time.NewTicker(peerUpdateInterval),
 		shut:         make(chan struct{}),
 		startMining:  make(chan bool, 1),
 		cancelMining: make(chan bool, 1),
+		blockSharing: make(chan database.Block, maxBlockShareRequests),
+		txSharing:    make(chan database.BlockTx, maxTxShareRequests),
 		evHandler:    evHandler,
 	}
 
@@ -41,12 +63,23 @@ func Run(st *state.State, evHandler state.EventHandler) {
 
 	// Select the consensus operation to run.
 	consensusOperation := w.powOperations
+	if st.Consensus() == state.ConsensusPOA {
+		consensusOperation = w.poaOperations
+	}
 
 	// Load the set of operations we need to run.
 	operations := []func(){
+		w.peerOperations,
+		w.shareBlockOperations,
+		w.shareTxOperations,
 		consensusOperation,
 	}
 
+	// Only sweep the mempool if transactions expire.
+	if st.MempoolTTL() > 0 {
+		operations = append(operations, w.expireOperations)
+	}
+
 	// Set waitgroup to match the number of G's we need for the set
 	// of operations we have.
 	g := len(operations)
@@ -81,6 +114,9 @@ func (w *Worker) Shutdown() {
 	w.evHandler("worker: shutdown: started")
 	defer w.evHandler("worker: shutdown: completed")
 
+	w.evHandler("worker: shutdown: stop ticker")
+	w.ticker.Stop()
+
 	w.evHandler("worker: shutdown: signal cancel mining")
 	w.SignalCancelMining()
 
@@ -113,6 +149,31 @@ func (w *Worker) SignalCancelMining() {
 	w.evHandler("worker: SignalCancelMining: MINING: CANCEL: signaled")
 }
 
+// SignalShareTx signals a share transaction operation. If
+// maxTxShareRequests signals exist in the channel, we won't send these.
+func (w *Worker) SignalShareTx(blockTx database.BlockTx) {
+	select {
+	case w.txSharing <- blockTx:
+		w.evHandler("worker: SignalShareTx: share Tx signaled")
+	default:
+		w.evHandler("worker: SignalShareTx: queue full, transactions won't be shared")
+	}
+}
+
+// =============================================================================
+
+// SignalShareBlock queues a newly mined block to be sent to the known peers.
+// If maxBlockShareRequests signals exist in the channel, the block won't
+// be shared.
+func (w *Worker) SignalShareBlock(block database.Block) {
+	select {
+	case w.blockSharing <- block:
+		w.evHandler("worker: SignalShareBlock: share block signaled")
+	default:
+		w.evHandler("worker: SignalShareBlock: queue full, block won't be shared")
+	}
+}
+
 // =============================================================================
 
 // isShutdown is used to test if a shutdown has been signaled.